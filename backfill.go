@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/webdevops/azure-loganalytics-exporter/loganalytics"
+)
+
+// runBackfill executes the backfill command and writes the OpenMetrics text to the output file
+func runBackfill() {
+	start, end, err := parseBackfillTimeRange(Opts.Backfill.Start, Opts.Backfill.End)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	params := url.Values{}
+	params.Set("module", Opts.Backfill.Module)
+
+	prober := loganalytics.NewLogAnalyticsJobProber(ctx, logger, params, &concurrentWaitGroup)
+	configureLogAnalyticsProber(prober)
+
+	workspaces := Opts.Backfill.Workspace
+	if len(workspaces) == 0 {
+		workspaces = Opts.Loganalytics.Workspace
+	}
+	prober.AddWorkspaces(workspaces...)
+
+	output := io.Writer(os.Stdout)
+	if Opts.Backfill.Output != "-" {
+		file, err := os.Create(Opts.Backfill.Output)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		defer file.Close() // nolint:errcheck
+		output = file
+	}
+
+	logger.Infof("starting backfill of metric %s from %s to %s", Opts.Backfill.Metric, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if err := prober.Backfill(output, Opts.Backfill.Metric, start, end, Opts.Backfill.Chunk); err != nil {
+		logger.Fatal(err.Error())
+	}
+	logger.Infof("finished backfill")
+}
+
+// parseBackfillTimeRange parses the time range, start can also be a duration before end
+func parseBackfillTimeRange(startValue, endValue string) (start time.Time, end time.Time, err error) {
+	end = time.Now()
+	if endValue != "" {
+		if end, err = time.Parse(time.RFC3339, endValue); err != nil {
+			return start, end, fmt.Errorf("invalid end: %w", err)
+		}
+	}
+
+	if start, err = time.Parse(time.RFC3339, startValue); err != nil {
+		duration, durationErr := time.ParseDuration(startValue)
+		if durationErr != nil {
+			return start, end, fmt.Errorf("invalid start: %w", err)
+		}
+		start, err = end.Add(-duration), nil
+	}
+
+	return start, end, nil
+}
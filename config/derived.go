@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+type (
+	// DerivedMetric defines a metric computed from the results of other metrics of the same module
+	DerivedMetric struct {
+		MetricMeta
+		Metric string   `json:"metric"`
+		Module string   `json:"module"`
+		Expr   string   `json:"expr"`
+		On     []string `json:"on"`
+
+		expression *Expression
+	}
+)
+
+func (d *DerivedMetric) Validate() error {
+	if d.Metric == "" {
+		return errors.New("no metric name set")
+	}
+
+	if err := d.MetricMeta.Validate(); err != nil {
+		return err
+	}
+
+	switch d.GetMetricType() {
+	case MetricTypeGauge, MetricTypeCounter:
+	default:
+		return fmt.Errorf("metricType \"%s\" is not supported for derived metrics", d.MetricType)
+	}
+
+	if d.Expr == "" {
+		return errors.New("no expr set")
+	}
+
+	expression, err := ParseExpression(d.Expr)
+	if err != nil {
+		return fmt.Errorf("invalid expr: %w", err)
+	}
+	d.expression = expression
+
+	return nil
+}
+
+// GetExpression returns the parsed expression (set by Validate)
+func (d *DerivedMetric) GetExpression() *Expression {
+	return d.expression
+}
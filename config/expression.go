@@ -0,0 +1,232 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	// Expression is a parsed arithmetic expression over metric names (operators + - * /, parentheses and numbers)
+	Expression struct {
+		root    expressionNode
+		metrics []string
+	}
+
+	expressionNode interface {
+		eval(values map[string]float64) float64
+	}
+
+	expressionNumber float64
+
+	expressionMetric string
+
+	expressionNegate struct {
+		node expressionNode
+	}
+
+	expressionBinary struct {
+		operator    rune
+		left, right expressionNode
+	}
+
+	// expressionParser is a recursive descent parser for expressions
+	expressionParser struct {
+		input   []rune
+		pos     int
+		metrics []string
+	}
+)
+
+// ParseExpression parses the arithmetic expression
+func ParseExpression(expr string) (*Expression, error) {
+	parser := &expressionParser{input: []rune(expr)}
+
+	root, err := parser.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.skipSpaces(); parser.pos < len(parser.input) {
+		return nil, fmt.Errorf("unexpected \"%s\" at position %v", string(parser.input[parser.pos]), parser.pos+1)
+	}
+
+	if len(parser.metrics) == 0 {
+		return nil, fmt.Errorf("expression doesn't reference any metric")
+	}
+
+	return &Expression{root: root, metrics: parser.metrics}, nil
+}
+
+// Metrics returns the metric names referenced by the expression
+func (e *Expression) Metrics() []string {
+	return e.metrics
+}
+
+// Eval evaluates the expression with the passed metric values
+func (e *Expression) Eval(values map[string]float64) float64 {
+	return e.root.eval(values)
+}
+
+func (n expressionNumber) eval(values map[string]float64) float64 {
+	return float64(n)
+}
+
+func (n expressionMetric) eval(values map[string]float64) float64 {
+	return values[string(n)]
+}
+
+func (n expressionNegate) eval(values map[string]float64) float64 {
+	return -n.node.eval(values)
+}
+
+func (n expressionBinary) eval(values map[string]float64) float64 {
+	left, right := n.left.eval(values), n.right.eval(values)
+	switch n.operator {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	default:
+		return left / right
+	}
+}
+
+// parseSum parses additions and subtractions
+func (p *expressionParser) parseSum() (expressionNode, error) {
+	node, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := p.peek()
+		if operator != '+' && operator != '-' {
+			return node, nil
+		}
+		p.pos++
+
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		node = expressionBinary{operator: operator, left: node, right: right}
+	}
+}
+
+// parseProduct parses multiplications and divisions
+func (p *expressionParser) parseProduct() (expressionNode, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := p.peek()
+		if operator != '*' && operator != '/' {
+			return node, nil
+		}
+		p.pos++
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		node = expressionBinary{operator: operator, left: node, right: right}
+	}
+}
+
+// parseUnary parses negations, parentheses, numbers and metric names
+func (p *expressionParser) parseUnary() (expressionNode, error) {
+	switch char := p.peek(); {
+	case char == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case char == '-':
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return expressionNegate{node: node}, nil
+	case char == '(':
+		p.pos++
+		node, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing \")\" at position %v", p.pos+1)
+		}
+		p.pos++
+		return node, nil
+	case unicode.IsDigit(char) || char == '.':
+		return p.parseNumber()
+	case isMetricNameChar(char, true):
+		start := p.pos
+		for p.pos < len(p.input) && isMetricNameChar(p.input[p.pos], false) {
+			p.pos++
+		}
+		metricName := string(p.input[start:p.pos])
+		p.addMetric(metricName)
+		return expressionMetric(metricName), nil
+	default:
+		return nil, fmt.Errorf("unexpected \"%s\" at position %v", string(char), p.pos+1)
+	}
+}
+
+// parseNumber parses a number (with optional fraction and exponent)
+func (p *expressionParser) parseNumber() (expressionNode, error) {
+	start := p.pos
+	for p.pos < len(p.input) {
+		char := p.input[p.pos]
+		isExponentSign := (char == '+' || char == '-') && p.pos > start && strings.ContainsRune("eE", p.input[p.pos-1])
+		if !unicode.IsDigit(char) && char != '.' && char != 'e' && char != 'E' && !isExponentSign {
+			break
+		}
+		p.pos++
+	}
+
+	value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number \"%s\" at position %v", string(p.input[start:p.pos]), start+1)
+	}
+
+	return expressionNumber(value), nil
+}
+
+// peek returns the next non-space character (0 at the end of the expression)
+func (p *expressionParser) peek() rune {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *expressionParser) addMetric(metricName string) {
+	if !slices.Contains(p.metrics, metricName) {
+		p.metrics = append(p.metrics, metricName)
+	}
+}
+
+// isMetricNameChar returns true if the character is allowed in metric names (digits not as first character)
+func isMetricNameChar(char rune, first bool) bool {
+	switch {
+	case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char == '_', char == ':':
+		return true
+	case char >= '0' && char <= '9':
+		return !first
+	}
+
+	return false
+}
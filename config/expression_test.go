@@ -0,0 +1,126 @@
+package config
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{
+		"a":         2,
+		"b":         3,
+		"c":         4,
+		"zero":      0,
+		"metric:b_": 10,
+	}
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    float64
+		metrics []string
+	}{
+		{name: "metric", expr: "a", want: 2, metrics: []string{"a"}},
+		{name: "multiplication before addition", expr: "a + b * c", want: 14, metrics: []string{"a", "b", "c"}},
+		{name: "division before subtraction", expr: "c - a / a", want: 3, metrics: []string{"c", "a"}},
+		{name: "left associative subtraction", expr: "c - a - a", want: 0, metrics: []string{"c", "a"}},
+		{name: "left associative division", expr: "c / a / a", want: 1, metrics: []string{"c", "a"}},
+		{name: "parentheses", expr: "(a + b) * c", want: 20, metrics: []string{"a", "b", "c"}},
+		{name: "nested parentheses", expr: "((a + b) * (c - a)) / 2", want: 5, metrics: []string{"a", "b", "c"}},
+		{name: "unary minus", expr: "-a + b", want: 1, metrics: []string{"a", "b"}},
+		{name: "unary minus binds tighter than multiplication", expr: "-a * b", want: -6, metrics: []string{"a", "b"}},
+		{name: "double unary minus", expr: "--a", want: 2, metrics: []string{"a"}},
+		{name: "subtraction of negation", expr: "b - -a", want: 5, metrics: []string{"b", "a"}},
+		{name: "unary minus of parentheses", expr: "-(a + b)", want: -5, metrics: []string{"a", "b"}},
+		{name: "decimal number", expr: "a * 0.5", want: 1, metrics: []string{"a"}},
+		{name: "leading dot number", expr: "a * .5", want: 1, metrics: []string{"a"}},
+		{name: "exponent number", expr: "a * 1e3", want: 2000, metrics: []string{"a"}},
+		{name: "negative exponent number", expr: "a * 1e-3", want: 0.002, metrics: []string{"a"}},
+		{name: "positive exponent number", expr: "a * 2.5E+2", want: 500, metrics: []string{"a"}},
+		{name: "number minus exponent number", expr: "1-1e-3 + a", want: 2.999, metrics: []string{"a"}},
+		{name: "without spaces", expr: "a*b+c/a", want: 8, metrics: []string{"a", "b", "c"}},
+		{name: "metric name with colon and digits", expr: "metric:b_ / a", want: 5, metrics: []string{"metric:b_", "a"}},
+		{name: "duplicate metric", expr: "a * a + a", want: 6, metrics: []string{"a"}},
+		{name: "unknown metric value is zero", expr: "a + missing", want: 2, metrics: []string{"a", "missing"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := ParseExpression(test.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := expression.Eval(values); math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+			if !slices.Equal(expression.Metrics(), test.metrics) {
+				t.Errorf("got metrics %v, want %v", expression.Metrics(), test.metrics)
+			}
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "empty", expr: ""},
+		{name: "only spaces", expr: "   "},
+		{name: "only number", expr: "1 + 2"},
+		{name: "unbalanced open parenthesis", expr: "(a + b"},
+		{name: "unbalanced nested parenthesis", expr: "((a + b) * c"},
+		{name: "unbalanced close parenthesis", expr: "a + b)"},
+		{name: "empty parentheses", expr: "a * ()"},
+		{name: "trailing operator", expr: "a +"},
+		{name: "leading operator", expr: "* a"},
+		{name: "double operator", expr: "a * / b"},
+		{name: "trailing metric", expr: "a b"},
+		{name: "trailing number", expr: "a 1"},
+		{name: "trailing characters", expr: "a + b;"},
+		{name: "unsupported operator", expr: "a % b"},
+		{name: "unary plus", expr: "+a"},
+		{name: "incomplete exponent", expr: "a * 1e"},
+		{name: "incomplete negative exponent", expr: "a * 1e-"},
+		{name: "multiple dots", expr: "a * 1.2.3"},
+		{name: "metric name starting with digit", expr: "1a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if expression, err := ParseExpression(test.expr); err == nil {
+				t.Fatalf("expected error, got expression with metrics %v", expression.Metrics())
+			}
+		})
+	}
+}
+
+func TestExpressionDivideByZero(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		values map[string]float64
+		check  func(float64) bool
+	}{
+		{name: "positive by zero", expr: "a / b", values: map[string]float64{"a": 1, "b": 0}, check: func(v float64) bool { return math.IsInf(v, 1) }},
+		{name: "negative by zero", expr: "a / b", values: map[string]float64{"a": -1, "b": 0}, check: func(v float64) bool { return math.IsInf(v, -1) }},
+		{name: "zero by zero", expr: "a / b", values: map[string]float64{"a": 0, "b": 0}, check: math.IsNaN},
+		{name: "by zero literal", expr: "a / 0", values: map[string]float64{"a": 1}, check: func(v float64) bool { return math.IsInf(v, 1) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := ParseExpression(test.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := expression.Eval(test.values); !test.check(got) {
+				t.Errorf("unexpected result %v", got)
+			}
+		})
+	}
+}
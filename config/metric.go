@@ -0,0 +1,370 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webdevops/go-common/prometheus/kusto"
+)
+
+const (
+	MetricTypeGauge     = "gauge"
+	MetricTypeCounter   = "counter"
+	MetricTypeHistogram = "histogram"
+	MetricTypeSummary   = "summary"
+
+	MetricBucketLabelDefault   = "le"
+	MetricQuantileLabelDefault = "quantile"
+
+	MetricTimestampMaxAgeDefault = time.Hour
+
+	// MetricFieldTypeTimestamp marks the datetime column used as sample timestamp
+	MetricFieldTypeTimestamp = "timestamp"
+
+	// MetricFieldTypeExemplar marks a column attached as exemplar label to the sample
+	MetricFieldTypeExemplar = "exemplar"
+
+	// value policies for null and non-numeric values of value fields
+	MetricValuePolicyDrop  = "drop"
+	MetricValuePolicyNaN   = "nan"
+	MetricValuePolicyFixed = "value"
+
+	MetricNativeHistogramBucketFactorDefault = 1.1
+	MetricNativeHistogramSchemaMin           = -4
+	MetricNativeHistogramSchemaMax           = 8
+)
+
+var (
+	metricUnitRegexp    = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	exemplarLabelRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type (
+	// MetricMeta contains the exposition settings of a metric (query metric or sub metric of a field)
+	MetricMeta struct {
+		Help          string `json:"help"`
+		Unit          string `json:"unit"`
+		MetricType    string `json:"metricType"`
+		BucketLabel   string `json:"bucketLabel"`
+		QuantileLabel string `json:"quantileLabel"`
+		Cumulative    bool   `json:"cumulative"`
+
+		// histogram from bin() rows and native histograms
+		BinSize                     float64 `json:"binSize"`
+		NativeHistogram             bool    `json:"nativeHistogram"`
+		NativeHistogramBucketFactor float64 `json:"nativeHistogramBucketFactor"`
+
+		// samples with explicit timestamps (field type timestamp)
+		TimestampMaxAge string `json:"timestampMaxAge"`
+	}
+
+	// MetricFieldExtension contains the field settings which are not handled by the kusto processing library
+	MetricFieldExtension struct {
+		MetricMeta
+		Name   string `json:"name"`
+		Metric string `json:"metric"`
+		Type   string `json:"type"`
+
+		// value fields: handling of null and non-numeric values (default: drop)
+		NullValue       *MetricValuePolicy `json:"nullValue"`
+		NonNumericValue *MetricValuePolicy `json:"nonNumericValue"`
+
+		// value fields of summaries: quantile of the column (eg. 0.95 or 95, sum or count)
+		Quantile *MetricFieldQuantile `json:"quantile"`
+	}
+
+	// MetricFieldQuantile is the quantile (or percentile, sum and count) of a summary value column
+	MetricFieldQuantile string
+
+	// MetricValuePolicy defines how null or non-numeric values are handled: drop the sample,
+	// expose NaN or expose a fixed value (set as number)
+	MetricValuePolicy struct {
+		Action string
+		Value  float64
+	}
+)
+
+func (m *MetricMeta) Validate() error {
+	switch m.GetMetricType() {
+	case MetricTypeGauge, MetricTypeCounter, MetricTypeHistogram, MetricTypeSummary:
+	default:
+		return fmt.Errorf("unsupported metricType \"%s\"", m.MetricType)
+	}
+
+	if m.Help != "" && strings.TrimSpace(m.Help) == "" {
+		return errors.New("help must not be blank")
+	}
+
+	if m.Unit != "" && !metricUnitRegexp.MatchString(m.Unit) {
+		return fmt.Errorf("invalid unit \"%s\" (lowercase letters, digits and underscores)", m.Unit)
+	}
+
+	if m.BinSize < 0 {
+		return errors.New("binSize must not be negative")
+	}
+
+	if m.NativeHistogramBucketFactor != 0 && m.NativeHistogramBucketFactor <= 1 {
+		return errors.New("nativeHistogramBucketFactor must be greater than 1")
+	}
+
+	if m.TimestampMaxAge != "" {
+		maxAge, err := time.ParseDuration(m.TimestampMaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid timestampMaxAge: %w", err)
+		}
+
+		if maxAge.Seconds() <= 0 {
+			return errors.New("timestampMaxAge must be greater than zero")
+		}
+	}
+
+	return nil
+}
+
+// IsEmpty returns true if no exposition settings are configured
+func (m *MetricMeta) IsEmpty() bool {
+	return *m == MetricMeta{}
+}
+
+// ValidateMetricName checks if the metric name is compatible with the settings (unit suffix)
+func (m *MetricMeta) ValidateMetricName(metricName string) error {
+	if m.Unit == "" {
+		return nil
+	}
+
+	unitSuffix := "_" + m.Unit
+	if strings.HasSuffix(metricName, unitSuffix) {
+		return nil
+	}
+
+	if m.GetMetricType() == MetricTypeCounter && strings.HasSuffix(metricName, unitSuffix+"_total") {
+		return nil
+	}
+
+	return fmt.Errorf("metric name \"%s\" must end with unit suffix \"%s\"", metricName, unitSuffix)
+}
+
+// GetHelp returns the help text of the metric, falls back to the metric name
+func (m *MetricMeta) GetHelp(metricName string) string {
+	if help := strings.TrimSpace(m.Help); help != "" {
+		return help
+	}
+
+	return metricName
+}
+
+// GetMetricType returns the prometheus metric type, defaults to gauge
+func (m *MetricMeta) GetMetricType() string {
+	if m.MetricType == "" {
+		return MetricTypeGauge
+	}
+
+	return strings.ToLower(m.MetricType)
+}
+
+// GetBucketLabel returns the label containing the upper bound of histogram buckets
+func (m *MetricMeta) GetBucketLabel() string {
+	if m.BucketLabel == "" {
+		return MetricBucketLabelDefault
+	}
+
+	return m.BucketLabel
+}
+
+// GetQuantileLabel returns the label containing the quantile of summaries
+func (m *MetricMeta) GetQuantileLabel() string {
+	if m.QuantileLabel == "" {
+		return MetricQuantileLabelDefault
+	}
+
+	return m.QuantileLabel
+}
+
+// GetNativeHistogramSchema returns the native histogram schema for the configured bucket factor,
+// the resulting bucket growth is not bigger than the bucket factor
+func (m *MetricMeta) GetNativeHistogramSchema() int32 {
+	bucketFactor := m.NativeHistogramBucketFactor
+	if bucketFactor == 0 {
+		bucketFactor = MetricNativeHistogramBucketFactorDefault
+	}
+
+	schema := -math.Floor(math.Log2(math.Log2(bucketFactor)))
+	schema = math.Max(schema, MetricNativeHistogramSchemaMin)
+	schema = math.Min(schema, MetricNativeHistogramSchemaMax)
+	return int32(schema)
+}
+
+// GetTimestampMaxAge returns the maximum age of sample timestamps, older samples are dropped
+func (m *MetricMeta) GetTimestampMaxAge() time.Duration {
+	if maxAge, err := time.ParseDuration(m.TimestampMaxAge); err == nil && maxAge > 0 {
+		return maxAge
+	}
+
+	return MetricTimestampMaxAgeDefault
+}
+
+// normalized returns the meta with applied defaults for comparison
+func (m MetricMeta) normalized() MetricMeta {
+	if m.NativeHistogramBucketFactor == 0 {
+		m.NativeHistogramBucketFactor = MetricNativeHistogramBucketFactorDefault
+	}
+
+	return MetricMeta{
+		Help:          strings.TrimSpace(m.Help),
+		Unit:          m.Unit,
+		MetricType:    m.GetMetricType(),
+		BucketLabel:   m.GetBucketLabel(),
+		QuantileLabel: m.GetQuantileLabel(),
+		Cumulative:    m.Cumulative,
+
+		BinSize:                     m.BinSize,
+		NativeHistogram:             m.NativeHistogram,
+		NativeHistogramBucketFactor: m.NativeHistogramBucketFactor,
+
+		TimestampMaxAge: m.GetTimestampMaxAge().String(),
+	}
+}
+
+// IsTypeValue returns true if the field contains the metric value
+func (f *MetricFieldExtension) IsTypeValue() bool {
+	return strings.EqualFold(f.Type, kusto.MetricFieldTypeValue)
+}
+
+// GetMetricName returns the metric name of the field, falls back to the main metric name
+func (f *MetricFieldExtension) GetMetricName(mainMetricName string) string {
+	if f.Metric != "" {
+		return f.Metric
+	}
+
+	return mainMetricName
+}
+
+func (p *MetricValuePolicy) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		p.Action = MetricValuePolicyFixed
+		p.Value = value
+		return nil
+	}
+
+	var action string
+	if err := json.Unmarshal(data, &action); err != nil {
+		return fmt.Errorf("invalid value policy %s (drop, nan or number)", string(data))
+	}
+
+	switch action = strings.ToLower(strings.TrimSpace(action)); action {
+	case MetricValuePolicyDrop, MetricValuePolicyNaN:
+		p.Action = action
+	default:
+		value, err := strconv.ParseFloat(action, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value policy \"%s\" (drop, nan or number)", action)
+		}
+		p.Action = MetricValuePolicyFixed
+		p.Value = value
+	}
+
+	return nil
+}
+
+// Apply returns the value exposed instead of the null or non-numeric value, false if the sample is dropped
+func (p *MetricValuePolicy) Apply() (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	switch p.Action {
+	case MetricValuePolicyNaN:
+		return math.NaN(), true
+	case MetricValuePolicyFixed:
+		return p.Value, true
+	}
+
+	return 0, false
+}
+
+func (q *MetricFieldQuantile) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var number float64
+		if err := json.Unmarshal(data, &number); err != nil {
+			return fmt.Errorf("invalid quantile %s (number, sum or count)", string(data))
+		}
+		value = strconv.FormatFloat(number, 'f', -1, 64)
+	}
+
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "sum", "count":
+	default:
+		quantile, err := strconv.ParseFloat(value, 64)
+		if err != nil || quantile < 0 || quantile > 100 {
+			return fmt.Errorf("invalid quantile \"%s\" (0-1, percentile up to 100, sum or count)", value)
+		}
+	}
+
+	*q = MetricFieldQuantile(value)
+	return nil
+}
+
+// unmarshalFieldExtensions parses the additional settings of the fields list
+func unmarshalFieldExtensions(data []byte) ([]MetricFieldExtension, error) {
+	fields := struct {
+		Fields []MetricFieldExtension `json:"fields"`
+	}{}
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields.Fields, nil
+}
+
+// extractFields converts fields with types unknown to the kusto processing library (timestamp, exemplar)
+// into ignored fields and returns the original fields
+func extractFields(queryMetric *kusto.QueryMetric, fieldType string) (fields []kusto.MetricField) {
+	if queryMetric == nil {
+		return
+	}
+
+	for num, field := range queryMetric.Fields {
+		if field.GetType() == fieldType {
+			fields = append(fields, field)
+			queryMetric.Fields[num].Type = kusto.MetricFieldTypeIgnore
+		}
+	}
+
+	return
+}
+
+// validateValueFields checks that value policies and quantiles are only set for value fields
+func validateValueFields(fieldExtensions []MetricFieldExtension) error {
+	for _, field := range fieldExtensions {
+		if (field.NullValue != nil || field.NonNumericValue != nil) && !field.IsTypeValue() {
+			return fmt.Errorf("field \"%v\": nullValue and nonNumericValue are only supported for fields with type value", field.Name)
+		}
+
+		if field.Quantile != nil && !field.IsTypeValue() {
+			return fmt.Errorf("field \"%v\": quantile is only supported for fields with type value", field.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateExemplarFields checks the exemplar label names
+func validateExemplarFields(fields []kusto.MetricField) error {
+	for _, field := range fields {
+		labelName := field.GetTargetFieldName(field.Name)
+		if !exemplarLabelRegexp.MatchString(labelName) {
+			return fmt.Errorf("field \"%v\": invalid exemplar label name \"%v\"", field.Name, labelName)
+		}
+	}
+
+	return nil
+}
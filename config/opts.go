@@ -20,16 +20,44 @@ type (
 		Azure struct {
 			Environment      *string `long:"azure.environment"            env:"AZURE_ENVIRONMENT"                description:"Azure environment name" default:"AZUREPUBLICCLOUD"`
 			ServiceDiscovery struct {
-				CacheDuration *time.Duration `long:"azure.servicediscovery.cache"            env:"AZURE_SERVICEDISCOVERY_CACHE"                description:"Duration for caching Azure ServiceDiscovery of workspaces to reduce API calls (time.Duration)" default:"30m"`
+				CacheDuration             *time.Duration `long:"azure.servicediscovery.cache"               env:"AZURE_SERVICEDISCOVERY_CACHE"               description:"Duration for caching Azure ServiceDiscovery of workspaces to reduce API calls (time.Duration)" default:"30m"`
+				SubscriptionCacheDuration *time.Duration `long:"azure.servicediscovery.subscription-cache"  env:"AZURE_SERVICEDISCOVERY_SUBSCRIPTION_CACHE"  description:"Duration for caching the list of accessible subscriptions for tenant discovery (time.Duration)" default:"1h"`
+				AllowFilter               bool           `long:"azure.servicediscovery.allow-filter"        env:"AZURE_SERVICEDISCOVERY_ALLOW_FILTER"        description:"Allow raw ResourceGraph filter parameter (filter) for service discovery requests"`
 			}
 			ResourceTags []string `long:"azure.resource-tag"      env:"AZURE_RESOURCE_TAG"        env-delim:" "  description:"Azure Resource tags (space delimiter)"                              default:"owner"`
 		}
 
 		Loganalytics struct {
-			Workspace   []string `long:"loganalytics.workspace"    env:"LOGANALYTICS_WORKSPACE"  env-delim:" " description:"Loganalytics workspace IDs"`
-			Concurrency int      `long:"loganalytics.concurrency"  env:"LOGANALYTICS_CONCURRENCY"              description:"Specifies how many workspaces should be queried concurrently" default:"5"`
+			Workspace   []string      `long:"loganalytics.workspace"    env:"LOGANALYTICS_WORKSPACE"  env-delim:" " description:"Loganalytics workspace IDs"`
+			Concurrency int           `long:"loganalytics.concurrency"  env:"LOGANALYTICS_CONCURRENCY"              description:"Specifies how many workspaces should be queried concurrently" default:"5"`
+			StaleMaxAge time.Duration `long:"loganalytics.stale-max-age"  env:"LOGANALYTICS_STALE_MAX_AGE"        description:"Maximum age of last good results served for failed queries with onError: stale (time.Duration)" default:"1h"`
+			BatchSize   int           `long:"loganalytics.batch-size"     env:"LOGANALYTICS_BATCH_SIZE"           description:"Number of workspaces per query in multi mode (cross workspace queries are limited)" default:"100"`
+			MaxSeries   int           `long:"loganalytics.max-series"     env:"LOGANALYTICS_MAX_SERIES"           description:"Maximum number of series per metric and query, further series are folded into an \"other\" series (0 = unlimited)" default:"0"`
+
+			Retry struct {
+				Count      int           `long:"loganalytics.retry.count"        env:"LOGANALYTICS_RETRY_COUNT"        description:"Number of retries for throttled and transient query errors" default:"2"`
+				Backoff    time.Duration `long:"loganalytics.retry.backoff"      env:"LOGANALYTICS_RETRY_BACKOFF"      description:"Initial backoff between retries, doubled per retry (with jitter)" default:"1s"`
+				MaxBackoff time.Duration `long:"loganalytics.retry.max-backoff"  env:"LOGANALYTICS_RETRY_MAX_BACKOFF"  description:"Maximum backoff between retries" default:"30s"`
+			}
+		}
+
+		// scheduler
+		Scheduler struct {
+			Enabled  bool          `long:"scheduler.enable"    env:"SCHEDULER_ENABLE"    description:"Execute configured jobs in background and serve latest results instead of querying on scrape"`
+			Interval time.Duration `long:"scheduler.interval"  env:"SCHEDULER_INTERVAL"  description:"Default interval for background jobs (time.Duration)" default:"5m"`
 		}
 
+		// backfill command
+		Backfill struct {
+			Metric    string        `long:"metric"     description:"Metric name of the query which should be backfilled" required:"true"`
+			Module    string        `long:"module"     description:"Module of the query"`
+			Workspace []string      `long:"workspace"  description:"Workspace IDs which are queried (defaults to --loganalytics.workspace)"`
+			Start     string        `long:"start"      description:"Start of the time range (RFC3339 or time.Duration before end)" required:"true"`
+			End       string        `long:"end"        description:"End of the time range (RFC3339, defaults to now)"`
+			Chunk     time.Duration `long:"chunk"      description:"Time range per query, should be a multiple of the bin() size (time.Duration)" default:"24h"`
+			Output    string        `long:"output" short:"o"  description:"Output file for OpenMetrics text (- for stdout)" default:"-"`
+		} `command:"backfill" description:"Write historical samples of a query in OpenMetrics format (for promtool tsdb create-blocks-from openmetrics)"`
+
 		// config
 		Config struct {
 			Path string `long:"config" short:"c"  env:"CONFIG"   description:"Config path" required:"true"`
@@ -41,6 +69,8 @@ type (
 			Bind         string        `long:"server.bind"              env:"SERVER_BIND"           description:"Server address"        default:":8080"`
 			ReadTimeout  time.Duration `long:"server.timeout.read"      env:"SERVER_TIMEOUT_READ"   description:"Server read timeout"   default:"5s"`
 			WriteTimeout time.Duration `long:"server.timeout.write"     env:"SERVER_TIMEOUT_WRITE"  description:"Server write timeout"  default:"10s"`
+
+			ScrapeTimeoutOffset time.Duration `long:"server.timeout.scrape-offset"  env:"SERVER_TIMEOUT_SCRAPE_OFFSET"  description:"Safety offset subtracted from the Prometheus scrape timeout (and write timeout) for returning partial results in time"  default:"1s"`
 		}
 	}
 )
@@ -0,0 +1,730 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/webdevops/go-common/prometheus/kusto"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	QueryOnErrorSkip  = "skip"
+	QueryOnErrorFail  = "fail"
+	QueryOnErrorStale = "stale"
+)
+
+type (
+	QueryConfig struct {
+		Queries []Query         `json:"queries"`
+		Derived []DerivedMetric `json:"derived"`
+		Jobs    []JobConfig     `json:"jobs"`
+
+		metricMeta        map[string]MetricMeta
+		metricNames       map[string]bool
+		moduleMetricNames map[string]map[string]bool
+	}
+
+	Query struct {
+		kusto.Query
+		MetricMeta
+		Timeout         string       `json:"timeout"`
+		WorkspaceColumn string       `json:"workspaceColumn"`
+		Tables          []QueryTable `json:"tables"`
+		OnError         string       `json:"onError"`
+		BatchSize       int          `json:"batchSize"`
+		MaxSeries       int          `json:"maxSeries"`
+		TimestampBin    string       `json:"timestampBin"`
+		TimestampDelay  string       `json:"timestampDelay"`
+
+		timeout         *time.Duration
+		timestampBin    time.Duration
+		timestampDelay  time.Duration
+		fieldExtensions []MetricFieldExtension
+		timestampFields []kusto.MetricField
+		exemplarFields  []kusto.MetricField
+	}
+
+	// QueryTable defines the metric and field processing for one result table (matched by name or index)
+	QueryTable struct {
+		*kusto.QueryMetric
+		MetricMeta
+		Name   string `json:"name"`
+		Index  *int   `json:"index"`
+		Metric string `json:"metric"`
+
+		fieldExtensions []MetricFieldExtension
+		timestampFields []kusto.MetricField
+		exemplarFields  []kusto.MetricField
+	}
+
+	JobConfig struct {
+		Name             string   `json:"name"`
+		Module           string   `json:"module"`
+		Interval         string   `json:"interval"`
+		Workspaces       []string `json:"workspaces"`
+		Subscriptions    []string `json:"subscriptions"`
+		ManagementGroups []string `json:"managementGroups"`
+		Filter           string   `json:"filter"`
+		Optional         bool     `json:"optional"`
+
+		// service discovery selectors
+		Tags           []string `json:"tags"`
+		ResourceGroups []string `json:"resourceGroups"`
+		Locations      []string `json:"locations"`
+		Names          []string `json:"names"`
+		NamePatterns   []string `json:"namePatterns"`
+
+		// tenant discovery (all accessible subscriptions)
+		Tenant              bool     `json:"tenant"`
+		SubscriptionInclude []string `json:"subscriptionInclude"`
+		SubscriptionExclude []string `json:"subscriptionExclude"`
+
+		interval *time.Duration
+	}
+)
+
+func NewQueryConfig(path string) (config QueryConfig, err error) {
+	config = QueryConfig{}
+
+	/*  #nosec G304 */
+	filecontent, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	err = yaml.Unmarshal(filecontent, &config)
+	return
+}
+
+func (c *QueryConfig) Validate() error {
+	if len(c.Queries) == 0 {
+		return errors.New("no queries found")
+	}
+
+	c.metricMeta = map[string]MetricMeta{}
+	c.metricNames = map[string]bool{}
+	c.moduleMetricNames = map[string]map[string]bool{}
+	for num := range c.Queries {
+		if err := c.Queries[num].Validate(); err != nil {
+			return fmt.Errorf("query \"%v\": %w", c.Queries[num].Metric, err)
+		}
+
+		if err := c.addMetricMeta(&c.Queries[num]); err != nil {
+			return fmt.Errorf("query \"%v\": %w", c.Queries[num].Metric, err)
+		}
+	}
+
+	// quantile fields need the settings of all metrics (summary and quantile label)
+	for num := range c.Queries {
+		if err := c.applyQuantileFields(&c.Queries[num]); err != nil {
+			return fmt.Errorf("query \"%v\": %w", c.Queries[num].Metric, err)
+		}
+	}
+
+	// exemplar fields need the settings of all metrics (metric type)
+	for num := range c.Queries {
+		if err := c.validateExemplarMetrics(&c.Queries[num]); err != nil {
+			return fmt.Errorf("query \"%v\": %w", c.Queries[num].Metric, err)
+		}
+	}
+
+	for num := range c.Derived {
+		if err := c.validateDerivedMetric(&c.Derived[num]); err != nil {
+			return fmt.Errorf("derived metric \"%v\": %w", c.Derived[num].Metric, err)
+		}
+	}
+
+	if err := c.validateMetricNameCollisions(); err != nil {
+		return err
+	}
+
+	jobNames := map[string]bool{}
+	for num := range c.Jobs {
+		jobName := c.Jobs[num].GetName()
+		if err := c.Jobs[num].Validate(); err != nil {
+			return fmt.Errorf("job \"%v\": %w", jobName, err)
+		}
+
+		if _, exists := jobNames[jobName]; exists {
+			return fmt.Errorf("job \"%v\": duplicate job name", jobName)
+		}
+		jobNames[jobName] = true
+	}
+
+	return nil
+}
+
+// addMetricMeta collects the exposition settings of all metrics of the query and checks for conflicting settings
+func (c *QueryConfig) addMetricMeta(query *Query) error {
+	addMeta := func(metricName string, meta MetricMeta) error {
+		if err := meta.ValidateMetricName(metricName); err != nil {
+			return err
+		}
+
+		if existing, exists := c.metricMeta[metricName]; exists && existing.normalized() != meta.normalized() {
+			return fmt.Errorf("metric \"%v\" has conflicting settings in multiple queries or fields", metricName)
+		}
+		c.metricMeta[metricName] = meta
+		c.addMetricName(query.Module, metricName)
+		return nil
+	}
+
+	addFieldMeta := func(mainMetricName string, fieldExtensions []MetricFieldExtension) error {
+		for _, field := range fieldExtensions {
+			if field.Metric != "" {
+				c.addMetricName(query.Module, field.Metric)
+			}
+
+			if field.MetricMeta.IsEmpty() {
+				continue
+			}
+
+			if field.Metric == "" || field.Metric == mainMetricName {
+				return fmt.Errorf("field \"%v\": metric settings need an own metric name", field.Name)
+			}
+
+			if err := field.MetricMeta.Validate(); err != nil {
+				return fmt.Errorf("field \"%v\": %w", field.Name, err)
+			}
+
+			if err := addMeta(field.Metric, field.MetricMeta); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := addMeta(query.Metric, query.MetricMeta); err != nil {
+		return err
+	}
+
+	if err := addFieldMeta(query.Metric, query.fieldExtensions); err != nil {
+		return err
+	}
+
+	for _, table := range query.Tables {
+		tableMetricName := query.Metric
+		if table.Metric != "" && table.Metric != query.Metric {
+			tableMetricName = table.Metric
+			if err := addMeta(tableMetricName, table.MetricMeta); err != nil {
+				return err
+			}
+		}
+
+		if err := addFieldMeta(tableMetricName, table.fieldExtensions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateExemplarMetrics checks that exemplar fields are only set for counters and histograms,
+// exemplars are attached to all metrics of the result rows (main metric and fields with own metric)
+func (c *QueryConfig) validateExemplarMetrics(query *Query) error {
+	validate := func(mainMetricName string, queryMetric *kusto.QueryMetric, fieldExtensions []MetricFieldExtension, exemplarFields []kusto.MetricField) error {
+		if len(exemplarFields) == 0 {
+			return nil
+		}
+
+		metricNames := []string{}
+		if queryMetric == nil || queryMetric.IsPublished() {
+			metricNames = append(metricNames, mainMetricName)
+		}
+		for _, field := range fieldExtensions {
+			if field.Metric != "" && field.Metric != mainMetricName {
+				metricNames = append(metricNames, field.Metric)
+			}
+		}
+
+		for _, metricName := range metricNames {
+			metricMeta := c.GetMetricMeta(metricName)
+			switch metricType := metricMeta.GetMetricType(); metricType {
+			case MetricTypeCounter, MetricTypeHistogram:
+			default:
+				return fmt.Errorf("exemplar fields are only supported for counters and histograms, metric \"%v\" is a %v", metricName, metricType)
+			}
+		}
+
+		return nil
+	}
+
+	if err := validate(query.Metric, query.QueryMetric, query.fieldExtensions, query.exemplarFields); err != nil {
+		return err
+	}
+
+	for num, table := range query.Tables {
+		tableMetricName := query.Metric
+		if table.Metric != "" {
+			tableMetricName = table.Metric
+		}
+
+		if err := validate(tableMetricName, table.QueryMetric, table.fieldExtensions, table.exemplarFields); err != nil {
+			return fmt.Errorf("table #%v: %w", num, err)
+		}
+	}
+
+	return nil
+}
+
+// applyQuantileFields maps the value fields with quantile to own rows of their summary with the quantile label,
+// so multiple columns (eg. from percentiles()) are exposed as quantiles of one summary
+func (c *QueryConfig) applyQuantileFields(query *Query) error {
+	if err := c.applyFieldQuantiles(query.Metric, query.QueryMetric, query.fieldExtensions); err != nil {
+		return err
+	}
+
+	for num, table := range query.Tables {
+		tableMetricName := table.Metric
+		if tableMetricName == "" {
+			tableMetricName = query.Metric
+		}
+
+		if err := c.applyFieldQuantiles(tableMetricName, table.QueryMetric, table.fieldExtensions); err != nil {
+			return fmt.Errorf("table #%v: %w", num, err)
+		}
+	}
+
+	return nil
+}
+
+// applyFieldQuantiles sets metric name and quantile label of the fields with quantile (fields and field extensions
+// are parsed from the same list and share the index)
+func (c *QueryConfig) applyFieldQuantiles(mainMetricName string, queryMetric *kusto.QueryMetric, fieldExtensions []MetricFieldExtension) error {
+	for num, field := range fieldExtensions {
+		if field.Quantile == nil || num >= len(queryMetric.Fields) {
+			continue
+		}
+
+		metricName := field.GetMetricName(mainMetricName)
+		metricMeta := c.GetMetricMeta(metricName)
+		if metricMeta.GetMetricType() != MetricTypeSummary {
+			return fmt.Errorf("field \"%v\": quantile is only supported for metrics with metricType summary", field.Name)
+		}
+
+		metricField := &queryMetric.Fields[num]
+		metricField.Metric = metricName
+		if metricField.Labels == nil {
+			metricField.Labels = map[string]string{}
+		}
+		metricField.Labels[metricMeta.GetQuantileLabel()] = string(*field.Quantile)
+	}
+
+	return nil
+}
+
+// addMetricName remembers the metric name as produced by the module
+func (c *QueryConfig) addMetricName(moduleName, metricName string) {
+	if _, exists := c.moduleMetricNames[moduleName]; !exists {
+		c.moduleMetricNames[moduleName] = map[string]bool{}
+	}
+	c.moduleMetricNames[moduleName][metricName] = true
+	c.metricNames[metricName] = true
+}
+
+// validateDerivedMetric checks the derived metric, referenced metrics must be produced by queries
+// (or previous derived metrics) of the same module
+func (c *QueryConfig) validateDerivedMetric(derived *DerivedMetric) error {
+	if err := derived.Validate(); err != nil {
+		return err
+	}
+
+	if c.metricNames[derived.Metric] {
+		return fmt.Errorf("metric \"%v\" is already produced by a query or derived metric", derived.Metric)
+	}
+
+	for _, metricName := range derived.GetExpression().Metrics() {
+		if !c.moduleMetricNames[derived.Module][metricName] {
+			return fmt.Errorf("metric \"%v\" is not produced by a query of module \"%v\"", metricName, derived.Module)
+		}
+
+		metricMeta := c.GetMetricMeta(metricName)
+		switch metricMeta.GetMetricType() {
+		case MetricTypeGauge, MetricTypeCounter:
+		default:
+			return fmt.Errorf("metric \"%v\" must be a gauge or counter", metricName)
+		}
+	}
+
+	if err := derived.ValidateMetricName(derived.Metric); err != nil {
+		return err
+	}
+
+	c.metricMeta[derived.Metric] = derived.MetricMeta
+	c.addMetricName(derived.Module, derived.Metric)
+	return nil
+}
+
+// validateMetricNameCollisions checks if metric names collide with the exposed series names of
+// histograms and summaries (_bucket, _sum, _count) or counters (_total)
+func (c *QueryConfig) validateMetricNameCollisions() error {
+	for metricName, meta := range c.metricMeta {
+		suffixes := []string{}
+		switch meta.GetMetricType() {
+		case MetricTypeHistogram:
+			suffixes = []string{"_bucket", "_sum", "_count"}
+		case MetricTypeSummary:
+			suffixes = []string{"_sum", "_count"}
+		case MetricTypeCounter:
+			if !strings.HasSuffix(metricName, "_total") {
+				suffixes = []string{"_total"}
+			}
+		}
+
+		for _, suffix := range suffixes {
+			if c.metricNames[metricName+suffix] {
+				return fmt.Errorf("metric \"%v\" collides with series of %v metric \"%v\"", metricName+suffix, meta.GetMetricType(), metricName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetMetricMeta returns the exposition settings of a metric
+func (c *QueryConfig) GetMetricMeta(metricName string) MetricMeta {
+	if meta, exists := c.metricMeta[metricName]; exists {
+		return meta
+	}
+
+	return MetricMeta{}
+}
+
+func (c *Query) UnmarshalJSON(data []byte) (err error) {
+	type query Query
+	if err = json.Unmarshal(data, (*query)(c)); err != nil {
+		return err
+	}
+	c.timestampFields = extractFields(c.QueryMetric, MetricFieldTypeTimestamp)
+	c.exemplarFields = extractFields(c.QueryMetric, MetricFieldTypeExemplar)
+
+	c.fieldExtensions, err = unmarshalFieldExtensions(data)
+	return err
+}
+
+func (c *QueryTable) UnmarshalJSON(data []byte) (err error) {
+	type queryTable QueryTable
+	if err = json.Unmarshal(data, (*queryTable)(c)); err != nil {
+		return err
+	}
+	c.timestampFields = extractFields(c.QueryMetric, MetricFieldTypeTimestamp)
+	c.exemplarFields = extractFields(c.QueryMetric, MetricFieldTypeExemplar)
+
+	c.fieldExtensions, err = unmarshalFieldExtensions(data)
+	return err
+}
+
+func (c *Query) Validate() error {
+	if c.QueryMetric == nil {
+		if len(c.Tables) == 0 {
+			return errors.New("no field configuration set (fields, defaultField or tables)")
+		}
+
+		// field configuration is defined per table only
+		c.QueryMetric = &kusto.QueryMetric{}
+	}
+
+	if err := c.Query.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.MetricMeta.Validate(); err != nil {
+		return err
+	}
+
+	if c.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+
+		if timeout.Seconds() <= 0 {
+			return errors.New("timeout must be greater than zero")
+		}
+
+		c.timeout = &timeout
+	}
+
+	switch c.GetOnError() {
+	case QueryOnErrorSkip, QueryOnErrorFail, QueryOnErrorStale:
+	default:
+		return fmt.Errorf("unsupported onError policy \"%s\"", c.OnError)
+	}
+
+	if c.BatchSize < 0 {
+		return errors.New("batchSize must not be negative")
+	}
+
+	if c.MaxSeries < 0 {
+		return errors.New("maxSeries must not be negative")
+	}
+
+	if len(c.timestampFields) > 1 {
+		return errors.New("only one field with type timestamp allowed")
+	}
+
+	if c.TimestampBin != "" {
+		timestampBin, err := time.ParseDuration(c.TimestampBin)
+		if err != nil {
+			return fmt.Errorf("invalid timestampBin: %w", err)
+		}
+
+		if timestampBin.Seconds() <= 0 {
+			return errors.New("timestampBin must be greater than zero")
+		}
+
+		if !c.hasTimestampField() {
+			return errors.New("timestampBin requires a field with type timestamp")
+		}
+
+		c.timestampBin = timestampBin
+	}
+
+	if c.TimestampDelay != "" {
+		if c.TimestampBin == "" {
+			return errors.New("timestampDelay requires timestampBin")
+		}
+
+		timestampDelay, err := time.ParseDuration(c.TimestampDelay)
+		if err != nil {
+			return fmt.Errorf("invalid timestampDelay: %w", err)
+		}
+
+		if timestampDelay < 0 {
+			return errors.New("timestampDelay must not be negative")
+		}
+
+		c.timestampDelay = timestampDelay
+	}
+
+	if err := validateExemplarFields(c.exemplarFields); err != nil {
+		return err
+	}
+
+	if err := validateValueFields(c.fieldExtensions); err != nil {
+		return err
+	}
+
+	for num, table := range c.Tables {
+		if table.Name == "" && table.Index == nil {
+			return fmt.Errorf("table #%v: name or index must be set", num)
+		}
+
+		if table.QueryMetric == nil {
+			return fmt.Errorf("table #%v: no field configuration set", num)
+		}
+
+		if err := table.QueryMetric.Validate(); err != nil {
+			return fmt.Errorf("table #%v: %w", num, err)
+		}
+
+		if err := table.MetricMeta.Validate(); err != nil {
+			return fmt.Errorf("table #%v: %w", num, err)
+		}
+
+		if len(table.timestampFields) > 1 {
+			return fmt.Errorf("table #%v: only one field with type timestamp allowed", num)
+		}
+
+		if err := validateExemplarFields(table.exemplarFields); err != nil {
+			return fmt.Errorf("table #%v: %w", num, err)
+		}
+
+		if err := validateValueFields(table.fieldExtensions); err != nil {
+			return fmt.Errorf("table #%v: %w", num, err)
+		}
+	}
+
+	return nil
+}
+
+// GetOnError returns the error policy of the query, defaults to skip
+func (c *Query) GetOnError() string {
+	if c.OnError == "" {
+		return QueryOnErrorSkip
+	}
+
+	return strings.ToLower(c.OnError)
+}
+
+// GetBatchSize returns the number of workspaces per query in multi mode or the passed default if not set
+func (c *Query) GetBatchSize(defaultBatchSize int) int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+
+	return defaultBatchSize
+}
+
+// GetMaxSeries returns the maximum number of series per metric or the passed default if not set (0 = unlimited)
+func (c *Query) GetMaxSeries(defaultMaxSeries int) int {
+	if c.MaxSeries > 0 {
+		return c.MaxSeries
+	}
+
+	return defaultMaxSeries
+}
+
+// GetTableMetricConfig returns the metric name and field configuration for a result table,
+// falls back to the query metric configuration if no table mapping matches
+func (c *Query) GetTableMetricConfig(tableName string, tableIndex int) (string, kusto.QueryMetric) {
+	if table := c.getTable(tableName, tableIndex); table != nil {
+		metricName := table.Metric
+		if metricName == "" {
+			metricName = c.Metric
+		}
+		return metricName, *table.QueryMetric
+	}
+
+	if c.QueryMetric == nil {
+		return c.Metric, kusto.QueryMetric{}
+	}
+
+	return c.Metric, *c.QueryMetric
+}
+
+// GetTableTimestampField returns the column used as sample timestamp for a result table (empty if not set)
+func (c *Query) GetTableTimestampField(tableName string, tableIndex int) string {
+	timestampFields := c.timestampFields
+	if table := c.getTable(tableName, tableIndex); table != nil {
+		timestampFields = table.timestampFields
+	}
+
+	if len(timestampFields) > 0 {
+		return timestampFields[0].Name
+	}
+
+	return ""
+}
+
+// GetTableExemplarFields returns the columns attached as exemplar labels for a result table
+func (c *Query) GetTableExemplarFields(tableName string, tableIndex int) []kusto.MetricField {
+	if table := c.getTable(tableName, tableIndex); table != nil {
+		return table.exemplarFields
+	}
+
+	return c.exemplarFields
+}
+
+// GetTableValueFields returns the value fields (with null and non-numeric value policies) for a result table
+func (c *Query) GetTableValueFields(tableName string, tableIndex int) []MetricFieldExtension {
+	fieldExtensions := c.fieldExtensions
+	if table := c.getTable(tableName, tableIndex); table != nil {
+		fieldExtensions = table.fieldExtensions
+	}
+
+	valueFields := []MetricFieldExtension{}
+	for _, field := range fieldExtensions {
+		if field.IsTypeValue() {
+			valueFields = append(valueFields, field)
+		}
+	}
+
+	return valueFields
+}
+
+// getTable returns the table mapping matching the result table (nil if no mapping matches)
+func (c *Query) getTable(tableName string, tableIndex int) *QueryTable {
+	for num, table := range c.Tables {
+		if (table.Name != "" && strings.EqualFold(table.Name, tableName)) || (table.Index != nil && *table.Index == tableIndex) {
+			return &c.Tables[num]
+		}
+	}
+
+	return nil
+}
+
+// GetTimeout returns the query timeout (nil if not set)
+func (c *Query) GetTimeout() *time.Duration {
+	return c.timeout
+}
+
+// hasTimestampField returns true if the query or one of its tables has a field with type timestamp
+func (c *Query) hasTimestampField() bool {
+	if len(c.timestampFields) > 0 {
+		return true
+	}
+
+	for _, table := range c.Tables {
+		if len(table.timestampFields) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetTimestampBin returns the bin size of the timestamp column and the ingestion delay,
+// bins which are not complete yet are dropped (bin size zero if not set)
+func (c *Query) GetTimestampBin() (time.Duration, time.Duration) {
+	return c.timestampBin, c.timestampDelay
+}
+
+func (c *JobConfig) Validate() error {
+	if c.Interval != "" {
+		interval, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %w", err)
+		}
+
+		if interval.Seconds() <= 0 {
+			return errors.New("interval must be greater than zero")
+		}
+
+		c.interval = &interval
+	}
+
+	scopes := 0
+	for _, scope := range [][]string{c.Workspaces, c.Subscriptions, c.ManagementGroups} {
+		if len(scope) > 0 {
+			scopes++
+		}
+	}
+
+	if c.Tenant {
+		scopes++
+	}
+
+	if scopes > 1 {
+		return errors.New("workspaces, subscriptions, managementGroups and tenant cannot be used together")
+	}
+
+	if !c.Tenant && (len(c.SubscriptionInclude) > 0 || len(c.SubscriptionExclude) > 0) {
+		return errors.New("subscriptionInclude and subscriptionExclude are only supported with tenant")
+	}
+
+	return nil
+}
+
+// GetName returns the job name, falls back to module name
+func (c *JobConfig) GetName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	return c.Module
+}
+
+// GetInterval returns the job interval or the passed default if no interval is configured
+func (c *JobConfig) GetInterval(defaultInterval time.Duration) time.Duration {
+	if c.interval != nil {
+		return *c.interval
+	}
+
+	return defaultInterval
+}
+
+// IsServiceDiscovery returns true if workspaces are discovered via subscriptions
+func (c *JobConfig) IsServiceDiscovery() bool {
+	return len(c.Subscriptions) > 0
+}
+
+// IsManagementGroupServiceDiscovery returns true if workspaces are discovered via management groups
+func (c *JobConfig) IsManagementGroupServiceDiscovery() bool {
+	return len(c.ManagementGroups) > 0
+}
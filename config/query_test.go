@@ -0,0 +1,147 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestValidateExemplarMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "counter",
+			config: `
+queries:
+  - metric: requests_total
+    metricType: counter
+    query: Requests
+    fields:
+      - {name: count, type: value}
+      - {name: OperationId, type: exemplar, target: trace_id}
+`,
+		},
+		{
+			name: "histogram",
+			config: `
+queries:
+  - metric: latency
+    metricType: histogram
+    query: Requests
+    fields:
+      - {name: le, type: id}
+      - {name: count, type: value}
+      - {name: OperationId, type: exemplar}
+`,
+		},
+		{
+			name: "gauge",
+			config: `
+queries:
+  - metric: requests
+    query: Requests
+    fields:
+      - {name: count, type: value}
+      - {name: OperationId, type: exemplar}
+`,
+			wantErr: `metric "requests" is a gauge`,
+		},
+		{
+			name: "summary",
+			config: `
+queries:
+  - metric: latency
+    metricType: summary
+    query: Requests
+    fields:
+      - {name: quantile, type: id}
+      - {name: value, type: value}
+      - {name: OperationId, type: exemplar}
+`,
+			wantErr: `metric "latency" is a summary`,
+		},
+		{
+			name: "field metric gauge",
+			config: `
+queries:
+  - metric: requests_total
+    metricType: counter
+    query: Requests
+    fields:
+      - {name: count, type: value}
+      - {name: duration, type: value, metric: requests_duration}
+      - {name: OperationId, type: exemplar}
+`,
+			wantErr: `metric "requests_duration" is a gauge`,
+		},
+		{
+			name: "unpublished main metric",
+			config: `
+queries:
+  - metric: requests
+    query: Requests
+    publish: false
+    fields:
+      - {name: count, type: value, metric: requests_total, metricType: counter}
+      - {name: OperationId, type: exemplar}
+`,
+		},
+		{
+			name: "table gauge",
+			config: `
+queries:
+  - metric: requests_total
+    metricType: counter
+    query: Requests
+    tables:
+      - name: Errors
+        metric: errors
+        fields:
+          - {name: count, type: value}
+          - {name: OperationId, type: exemplar}
+`,
+			wantErr: `table #0: exemplar fields are only supported for counters and histograms, metric "errors" is a gauge`,
+		},
+		{
+			name: "table counter",
+			config: `
+queries:
+  - metric: requests_total
+    metricType: counter
+    query: Requests
+    tables:
+      - name: Errors
+        metric: errors_total
+        metricType: counter
+        fields:
+          - {name: count, type: value}
+          - {name: OperationId, type: exemplar}
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queryConfig := QueryConfig{}
+			if err := yaml.Unmarshal([]byte(test.config), &queryConfig); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err := queryConfig.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
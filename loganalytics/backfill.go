@@ -0,0 +1,165 @@
+package loganalytics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+// Backfill executes the query of the metric over the time range (split into chunks) and writes all samples
+// with the timestamp of their timestamp field in OpenMetrics format (eg. for promtool tsdb create-blocks-from openmetrics)
+func (p *LogAnalyticsProber) Backfill(w io.Writer, metricName string, start, end time.Time, chunkSize time.Duration) error {
+	if !start.Before(end) {
+		return errors.New("start of time range must be before end")
+	}
+
+	if chunkSize <= 0 {
+		return errors.New("chunk size must be greater than zero")
+	}
+
+	var backfillQuery *config.Query
+	for num, query := range p.QueryConfig.Queries {
+		if query.Metric == metricName && query.Module == p.config.moduleName {
+			backfillQuery = &p.QueryConfig.Queries[num]
+			break
+		}
+	}
+
+	if backfillQuery == nil {
+		return fmt.Errorf("no query with metric \"%s\" found in module \"%s\"", metricName, p.config.moduleName)
+	}
+
+	if len(p.workspaceList) == 0 && (backfillQuery.Workspaces == nil || len(*backfillQuery.Workspaces) == 0) {
+		return errors.New("no workspaces defined")
+	}
+
+	query := *backfillQuery
+	// incomplete history must not be written
+	query.OnError = config.QueryOnErrorFail
+
+	for chunkStart := start; chunkStart.Before(end); chunkStart = chunkStart.Add(chunkSize) {
+		chunkEnd := chunkStart.Add(chunkSize)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+
+		p.logger.Info("querying time range", slog.Time("start", chunkStart), slog.Time("end", chunkEnd))
+
+		timespan := string(azquery.NewTimeInterval(chunkStart, chunkEnd))
+		query.Timespan = &timespan
+		p.QueryConfig.Queries = []config.Query{query}
+
+		if err := p.executeQueries(); err != nil {
+			return err
+		}
+	}
+
+	return p.writeBackfillMetrics(w)
+}
+
+// writeBackfillMetrics writes the metric list in OpenMetrics format, rows are collected per timestamp
+// and the samples of each series are written in ascending order
+func (p *LogAnalyticsProber) writeBackfillMetrics(w io.Writer) error {
+	timestamps := []int64{}
+	metricLists := map[int64]*kusto.MetricList{}
+	droppedRows := 0
+
+	for _, metricName := range p.metricList.GetMetricNames() {
+		for _, row := range p.metricList.GetMetricList(metricName) {
+			timestamp := rowTimestamp(row)
+			if timestamp == nil {
+				droppedRows++
+				continue
+			}
+
+			unixMilli := timestamp.UnixMilli()
+			metricList, exists := metricLists[unixMilli]
+			if !exists {
+				metricList = &kusto.MetricList{}
+				metricList.Init()
+				metricLists[unixMilli] = metricList
+				timestamps = append(timestamps, unixMilli)
+			}
+			metricList.Add(metricName, row)
+		}
+	}
+
+	if droppedRows > 0 {
+		p.logger.Warn("rows without timestamp (field with type timestamp) are not written", slog.Int("rows", droppedRows))
+	}
+
+	slices.Sort(timestamps)
+
+	metricFamilies := map[string]*dto.MetricFamily{}
+	for _, timestamp := range timestamps {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&metricListCollector{
+			logger:      p.logger,
+			metricList:  metricLists[timestamp],
+			queryConfig: &p.QueryConfig,
+			backfill:    true,
+		})
+
+		timestampFamilies, err := newMetricFamilyGatherer(registry, &p.QueryConfig).Gather()
+		if err != nil {
+			// invalid metrics are skipped, write the remaining metrics
+			p.logger.Error("skipped metrics: " + err.Error())
+		}
+
+		for _, metricFamily := range timestampFamilies {
+			if existing, exists := metricFamilies[metricFamily.GetName()]; exists {
+				existing.Metric = append(existing.Metric, metricFamily.Metric...)
+			} else {
+				metricFamilies[metricFamily.GetName()] = metricFamily
+			}
+		}
+	}
+
+	metricNames := []string{}
+	for metricName := range metricFamilies {
+		metricNames = append(metricNames, metricName)
+	}
+	sort.Strings(metricNames)
+
+	encoder := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeOpenMetrics), expfmt.WithUnit())
+	for _, metricName := range metricNames {
+		metricFamily := metricFamilies[metricName]
+
+		// group samples by series, stable sort keeps ascending timestamps within series
+		sort.SliceStable(metricFamily.Metric, func(i, j int) bool {
+			return seriesKey(metricFamily.Metric[i]) < seriesKey(metricFamily.Metric[j])
+		})
+
+		if err := encoder.Encode(metricFamily); err != nil {
+			return err
+		}
+	}
+
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// seriesKey returns the identifying key of the series of a gathered metric (labels are sorted by the registry)
+func seriesKey(metric *dto.Metric) string {
+	parts := make([]string, len(metric.GetLabel()))
+	for num, label := range metric.GetLabel() {
+		parts[num] = label.GetName() + "=" + label.GetValue()
+	}
+	return strings.Join(parts, "\xff")
+}
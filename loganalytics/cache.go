@@ -0,0 +1,116 @@
+package loganalytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/webdevops/go-common/prometheus/kusto"
+)
+
+type (
+	metricCacheEntry struct {
+		Time    time.Time         `json:"time"`
+		Metrics *kusto.MetricList `json:"metrics"`
+	}
+)
+
+// fetchFromCache loads the metrics from cache, expired entries are served within the max stale duration
+// while one background refresh is running
+func (p *LogAnalyticsProber) fetchFromCache() bool {
+	if p.cache == nil || !p.config.cacheEnabled {
+		return false
+	}
+
+	v, ok := p.cache.Get(*p.config.cacheKey)
+	if !ok {
+		return false
+	}
+
+	cacheData, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+
+	entry := metricCacheEntry{Metrics: p.metricList}
+	if err := json.Unmarshal(cacheData, &entry); err != nil {
+		p.logger.Debug("unable to parse cached metrics")
+		return false
+	}
+	p.metricList = entry.Metrics
+
+	age := time.Since(entry.Time)
+	p.addResponseHeader("X-metrics-cached", "true")
+	p.addResponseHeader("X-metrics-cached-time", entry.Time.Format(time.RFC3339))
+	p.addResponseHeader("X-metrics-cached-age", strconv.FormatFloat(age.Seconds(), 'f', 0, 64))
+
+	if age > *p.config.cacheDuration {
+		p.logger.Debug("fetched stale metrics from cache")
+		p.addResponseHeader("X-metrics-cached-stale", "true")
+		p.revalidateCache()
+	} else {
+		p.logger.Debug("fetched metrics from cache")
+	}
+
+	return true
+}
+
+// storeToCache saves the metrics to cache, entry is kept for cache duration plus max stale duration
+func (p *LogAnalyticsProber) storeToCache() {
+	if p.cache == nil || !p.config.cacheEnabled {
+		return
+	}
+
+	p.logger.Debug("saving metrics to cache")
+	entry := metricCacheEntry{Time: time.Now(), Metrics: p.metricList}
+	if cacheData, err := json.Marshal(entry); err == nil {
+		p.addResponseHeader("X-metrics-cached-until", entry.Time.Add(*p.config.cacheDuration).Format(time.RFC3339))
+		p.cache.Set(*p.config.cacheKey, cacheData, *p.config.cacheDuration+p.config.cacheMaxStale)
+		p.logger.Debugf("saved metric to cache for %s", p.config.cacheDuration.String())
+	}
+}
+
+// newCacheRefresher returns a copy of the prober decoupled from the current request, trust of params is kept
+func (p *LogAnalyticsProber) newCacheRefresher() *LogAnalyticsProber {
+	refresher := *p
+	refresher.request = nil
+	refresher.response = nil
+	refresher.workspaceList = slices.Clone(p.workspaceList)
+	refresher.metricList = &kusto.MetricList{}
+	refresher.metricList.Init()
+	refresher.ServiceDiscovery.prober = &refresher
+	return &refresher
+}
+
+// revalidateCache refreshes the cache entry in background, only one refresh per cache key is running
+func (p *LogAnalyticsProber) revalidateCache() {
+	lockKey := *p.config.cacheKey + ":refresh"
+	if err := p.cache.Add(lockKey, true, *p.config.cacheDuration); err != nil {
+		// refresh already running
+		return
+	}
+
+	refresher := p.newCacheRefresher()
+
+	go func() {
+		defer p.cache.Delete(lockKey)
+		defer func() {
+			if err := recover(); err != nil {
+				refresher.logger.Error(fmt.Sprintf("cache refresh failed: %v", err))
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(p.ctx), *p.config.cacheDuration)
+		defer cancel()
+		refresher.ctx = ctx
+		refresher.queryCtx = ctx
+
+		refresher.logger.Debug("refreshing stale metrics cache")
+		if err := refresher.collect(); err != nil {
+			refresher.logger.Error(err.Error())
+		}
+	}()
+}
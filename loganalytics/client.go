@@ -0,0 +1,58 @@
+package loganalytics
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+type (
+	// LogsClientPool shares LogAnalytics query clients (and their http pipeline) between probes, goroutine safe
+	LogsClientPool struct {
+		clients map[string]*azquery.LogsClient
+		lock    sync.Mutex
+	}
+)
+
+func NewLogsClientPool() *LogsClientPool {
+	pool := LogsClientPool{}
+	pool.clients = map[string]*azquery.LogsClient{}
+	return &pool
+}
+
+// Get returns the LogsClient for the cloud environment and credential of the azure client, creates it if needed
+func (pool *LogsClientPool) Get(azureClient *armclient.ArmClient) (*azquery.LogsClient, error) {
+	return pool.get(string(azureClient.GetCloudName()), azureClient.GetCred(), azureClient.NewAzCoreClientOptions)
+}
+
+// get returns the LogsClient for the cloud environment and credential, client options are only built for new clients
+func (pool *LogsClientPool) get(cloudName string, cred azcore.TokenCredential, clientOptions func() *azcore.ClientOptions) (*azquery.LogsClient, error) {
+	key := fmt.Sprintf("%s:%p", cloudName, cred)
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if client, ok := pool.clients[key]; ok {
+		return client, nil
+	}
+
+	client, err := azquery.NewLogsClient(cred, newLogsClientOptions(clientOptions()))
+	if err != nil {
+		return nil, err
+	}
+
+	pool.clients[key] = client
+	return client, nil
+}
+
+// newLogsClientOptions returns the LogsClient options based on the azure client options
+func newLogsClientOptions(clientOptions *azcore.ClientOptions) *azquery.LogsClientOptions {
+	clientOpts := azquery.LogsClientOptions{ClientOptions: *clientOptions}
+	// retries are handled by queryWorkspaceWithRetry (--loganalytics.retry.*), disable sdk retry policy
+	clientOpts.Retry = policy.RetryOptions{MaxRetries: -1}
+	return &clientOpts
+}
@@ -0,0 +1,76 @@
+package loganalytics
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+type fakeTokenCredential struct{}
+
+func (cred *fakeTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "fake", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func newBenchmarkArmClient(b *testing.B) *armclient.ArmClient {
+	b.Helper()
+
+	azureClient, err := armclient.NewArmClientWithCloudName("AzurePublicCloud", slog.New(slog.DiscardHandler))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return azureClient
+}
+
+// BenchmarkLogsClientPoolGet measures fetching the shared client from the pool (per probe request)
+func BenchmarkLogsClientPoolGet(b *testing.B) {
+	azureClient := newBenchmarkArmClient(b)
+	cred := &fakeTokenCredential{}
+	pool := NewLogsClientPool()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		if _, err := pool.get(string(azureClient.GetCloudName()), cred, azureClient.NewAzCoreClientOptions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLogsClientPoolGetParallel measures fetching the shared client from the pool by concurrent probes
+func BenchmarkLogsClientPoolGetParallel(b *testing.B) {
+	azureClient := newBenchmarkArmClient(b)
+	cred := &fakeTokenCredential{}
+	pool := NewLogsClientPool()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := pool.get(string(azureClient.GetCloudName()), cred, azureClient.NewAzCoreClientOptions); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkLogsClientNew measures creating a new client per call (previous behaviour)
+func BenchmarkLogsClientNew(b *testing.B) {
+	azureClient := newBenchmarkArmClient(b)
+	cred := &fakeTokenCredential{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		if _, err := azquery.NewLogsClient(cred, newLogsClientOptions(azureClient.NewAzCoreClientOptions())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package loganalytics
+
+import (
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+// buildDerivedMetrics computes the derived metrics of the module and adds them to the metric list,
+// derived metrics are computed in order so they can use previous derived metrics
+func (p *LogAnalyticsProber) buildDerivedMetrics() {
+	for _, derived := range p.QueryConfig.Derived {
+		if derived.Module != p.config.moduleName {
+			continue
+		}
+
+		metrics := buildDerivedMetric(p.metricList, derived)
+		if len(metrics) == 0 {
+			p.logger.Debug("derived metric without results", slog.String("metric", derived.Metric))
+			continue
+		}
+
+		p.metricList.Add(derived.Metric, metrics...)
+	}
+}
+
+// buildDerivedMetric evaluates the expression for every label set (join labels) and timestamp which exists in all
+// referenced metrics, rows of a metric with the same join labels and timestamp are summed up. Rows without timestamp
+// are used for all timestamps of the other metrics, the derived row gets the timestamp of the joined rows.
+func buildDerivedMetric(metricList *kusto.MetricList, derived config.DerivedMetric) []kusto.MetricRow {
+	type derivedKey struct {
+		joinKey   string
+		timestamp string
+	}
+
+	expression := derived.GetExpression()
+	operands := expression.Metrics()
+
+	joinLabels := derived.On
+	if len(joinLabels) == 0 {
+		joinLabels = commonLabelNames(metricList, operands)
+	}
+
+	keys := []derivedKey{}
+	knownKeys := map[derivedKey]bool{}
+	joinLabelValues := map[string]map[string]string{}
+	operandValues := map[string]map[derivedKey]float64{}
+	for _, metricName := range operands {
+		operandValues[metricName] = map[derivedKey]float64{}
+		for _, row := range metricList.GetMetricList(metricName) {
+			if row.Value == nil {
+				continue
+			}
+
+			labels := map[string]string{}
+			labelValues := make([]string, len(joinLabels))
+			for num, labelName := range joinLabels {
+				labels[labelName] = row.Labels[labelName]
+				labelValues[num] = row.Labels[labelName]
+			}
+
+			key := derivedKey{
+				joinKey:   strings.Join(labelValues, "\xff"),
+				timestamp: row.Labels[TimestampLabelName],
+			}
+			if _, exists := joinLabelValues[key.joinKey]; !exists {
+				joinLabelValues[key.joinKey] = labels
+			}
+			if !knownKeys[key] {
+				knownKeys[key] = true
+				keys = append(keys, key)
+			}
+			operandValues[metricName][key] += *row.Value
+		}
+	}
+
+	ret := []kusto.MetricRow{}
+	for _, key := range keys {
+		values := map[string]float64{}
+		for _, metricName := range operands {
+			value, exists := operandValues[metricName][key]
+			if !exists {
+				// rows without timestamp are joined with all timestamps
+				value, exists = operandValues[metricName][derivedKey{joinKey: key.joinKey}]
+			}
+
+			if !exists {
+				// inner join, all metrics must have a value
+				break
+			}
+			values[metricName] = value
+		}
+
+		if len(values) != len(operands) {
+			continue
+		}
+
+		labels := prometheus.Labels{}
+		for labelName, labelValue := range joinLabelValues[key.joinKey] {
+			labels[labelName] = labelValue
+		}
+
+		if key.timestamp != "" {
+			labels[TimestampLabelName] = key.timestamp
+		}
+
+		value := expression.Eval(values)
+		ret = append(ret, kusto.MetricRow{
+			Labels: labels,
+			Value:  &value,
+		})
+	}
+
+	return ret
+}
+
+// commonLabelNames returns the sorted label names which are used by all metrics (without internal labels)
+func commonLabelNames(metricList *kusto.MetricList, metricNames []string) []string {
+	var ret []string
+	for num, metricName := range metricNames {
+		labelNames := slices.DeleteFunc(metricList.GetMetricLabelNames(metricName), isInternalLabel)
+		if num == 0 {
+			ret = labelNames
+			continue
+		}
+
+		ret = slices.DeleteFunc(ret, func(labelName string) bool {
+			return !slices.Contains(labelNames, labelName)
+		})
+	}
+
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,133 @@
+package loganalytics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+func TestBuildDerivedMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		on      []string
+		metrics map[string][]kusto.MetricRow
+		want    []string
+	}{
+		{
+			name: "matching labels",
+			expr: "bytes / rows",
+			metrics: map[string][]kusto.MetricRow{
+				"bytes": {newTestMetricRow(100, "t=a"), newTestMetricRow(60, "t=b")},
+				"rows":  {newTestMetricRow(10, "t=a"), newTestMetricRow(20, "t=b")},
+			},
+			want: []string{"{t=a} 10", "{t=b} 3"},
+		},
+		{
+			name: "labels not used by all metrics are ignored",
+			expr: "bytes / rows",
+			metrics: map[string][]kusto.MetricRow{
+				"bytes": {newTestMetricRow(100, "t=a", "region=eu")},
+				"rows":  {newTestMetricRow(10, "t=a")},
+			},
+			want: []string{"{t=a} 10"},
+		},
+		{
+			name: "rows with same join labels are summed",
+			expr: "bytes / rows",
+			on:   []string{"t"},
+			metrics: map[string][]kusto.MetricRow{
+				"bytes": {newTestMetricRow(100, "t=a", "region=eu"), newTestMetricRow(50, "t=a", "region=us")},
+				"rows":  {newTestMetricRow(10, "t=a", "region=eu"), newTestMetricRow(5, "t=a", "region=us")},
+			},
+			want: []string{"{t=a} 10"},
+		},
+		{
+			name: "missing operand",
+			expr: "bytes / rows",
+			metrics: map[string][]kusto.MetricRow{
+				"bytes": {newTestMetricRow(100, "t=a"), newTestMetricRow(60, "t=b")},
+				"rows":  {newTestMetricRow(10, "t=a"), newTestMetricRow(20, "t=c")},
+			},
+			want: []string{"{t=a} 10"},
+		},
+		{
+			name: "missing metric",
+			expr: "bytes / rows",
+			metrics: map[string][]kusto.MetricRow{
+				"bytes": {newTestMetricRow(100, "t=a")},
+			},
+			want: []string{},
+		},
+		{
+			name: "division by zero",
+			expr: "bytes / rows",
+			metrics: map[string][]kusto.MetricRow{
+				"bytes": {newTestMetricRow(100, "t=a"), newTestMetricRow(0, "t=b")},
+				"rows":  {newTestMetricRow(0, "t=a"), newTestMetricRow(0, "t=b")},
+			},
+			want: []string{"{t=a} +Inf", "{t=b} NaN"},
+		},
+		{
+			name: "timestamped rows are joined per timestamp",
+			expr: "rows * 10",
+			metrics: map[string][]kusto.MetricRow{
+				"rows": {
+					newTestMetricRow(100, "t=A", "__timestamp=1000"),
+					newTestMetricRow(200, "t=A", "__timestamp=2000"),
+				},
+			},
+			want: []string{"{__timestamp=1000,t=A} 1000", "{__timestamp=2000,t=A} 2000"},
+		},
+		{
+			name: "timestamped operands only join matching timestamps",
+			expr: "bytes / rows",
+			metrics: map[string][]kusto.MetricRow{
+				"bytes": {
+					newTestMetricRow(100, "t=a", "__timestamp=1000"),
+					newTestMetricRow(300, "t=a", "__timestamp=2000"),
+				},
+				"rows": {
+					newTestMetricRow(10, "t=a", "__timestamp=1000"),
+					newTestMetricRow(20, "t=a", "__timestamp=3000"),
+				},
+			},
+			want: []string{"{__timestamp=1000,t=a} 10"},
+		},
+		{
+			name: "operand without timestamp is joined with all timestamps",
+			expr: "rows / limit",
+			metrics: map[string][]kusto.MetricRow{
+				"rows": {
+					newTestMetricRow(100, "t=a", "__timestamp=1000"),
+					newTestMetricRow(200, "t=a", "__timestamp=2000"),
+				},
+				"limit": {newTestMetricRow(400, "t=a")},
+			},
+			want: []string{"{__timestamp=1000,t=a} 0.25", "{__timestamp=2000,t=a} 0.5"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricList := &kusto.MetricList{}
+			metricList.Init()
+			for metricName, rows := range test.metrics {
+				metricList.Add(metricName, rows...)
+			}
+
+			derived := config.DerivedMetric{Metric: "derived", Module: "test", Expr: test.expr, On: test.on}
+			if err := derived.Validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := formatTestMetricRows(buildDerivedMetric(metricList, derived))
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("got rows\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package loganalytics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/prometheus/kusto"
+)
+
+const (
+	// ExemplarLabelPrefix is the prefix of internal labels carrying exemplar labels,
+	// they're removed before exposition
+	ExemplarLabelPrefix = "__exemplar_"
+)
+
+// isInternalLabel returns true for labels which are only used internally (timestamp, exemplars)
+func isInternalLabel(labelName string) bool {
+	return strings.HasPrefix(labelName, "__")
+}
+
+// injectExemplarLabels adds the values of the exemplar columns of the result row to the metrics
+func injectExemplarLabels(metrics []kusto.MetricRow, resultRow map[string]interface{}, exemplarFields []kusto.MetricField) {
+	for _, field := range exemplarFields {
+		value, exists := resultRow[field.Name]
+		if !exists || value == nil {
+			continue
+		}
+
+		labelValue := fmt.Sprintf("%v", value)
+		if labelValue == "" {
+			continue
+		}
+
+		labelName := ExemplarLabelPrefix + field.GetTargetFieldName(field.Name)
+		for num := range metrics {
+			metrics[num].Labels[labelName] = labelValue
+		}
+	}
+}
+
+// rowExemplarLabels returns the exemplar labels of a metric row (nil if not set)
+func rowExemplarLabels(row kusto.MetricRow) prometheus.Labels {
+	var ret prometheus.Labels
+	for labelName, labelValue := range row.Labels {
+		if exemplarLabelName, found := strings.CutPrefix(labelName, ExemplarLabelPrefix); found {
+			if ret == nil {
+				ret = prometheus.Labels{}
+			}
+			ret[exemplarLabelName] = labelValue
+		}
+	}
+
+	return ret
+}
+
+// withExemplars attaches the exemplars to the metric (counters and histograms only),
+// the metric is returned without exemplars if the exemplars are invalid (eg. labels longer than 128 characters)
+func (c *metricListCollector) withExemplars(metric prometheus.Metric, metricName string, timestamp *time.Time, exemplars ...prometheus.Exemplar) prometheus.Metric {
+	if len(exemplars) == 0 {
+		return metric
+	}
+
+	if timestamp != nil {
+		for num := range exemplars {
+			exemplars[num].Timestamp = *timestamp
+		}
+	}
+
+	ret, err := prometheus.NewMetricWithExemplars(metric, exemplars...)
+	if err != nil {
+		prometheusMetricErrors.With(prometheus.Labels{"metric": metricName}).Inc()
+		if c.logger != nil {
+			c.logger.Warn(fmt.Sprintf("skipped exemplar of metric \"%s\": %v", metricName, err))
+		}
+		return metric
+	}
+
+	return ret
+}
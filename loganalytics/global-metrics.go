@@ -2,6 +2,13 @@ package loganalytics
 
 import "github.com/prometheus/client_golang/prometheus"
 
+const (
+	QueryStatusSuccess = "success"
+	QueryStatusError   = "error"
+	QueryStatusTimeout = "timeout"
+	QueryStatusStale   = "stale"
+)
+
 var (
 	prometheusQueryTime            *prometheus.SummaryVec
 	prometheusQueryResults         *prometheus.GaugeVec
@@ -9,6 +16,14 @@ var (
 	prometheusQueryStatus          *prometheus.GaugeVec
 	prometheusQueryLastSuccessfull *prometheus.GaugeVec
 	prometheusQueryWorkspaceCount  *prometheus.GaugeVec
+	prometheusQueryRetries         *prometheus.CounterVec
+	prometheusConcurrencyLimit     *prometheus.GaugeVec
+	prometheusConcurrencyWaitTime  *prometheus.SummaryVec
+	prometheusSeriesDropped        *prometheus.CounterVec
+	prometheusMetricErrors         *prometheus.CounterVec
+	prometheusValuesDropped        *prometheus.CounterVec
+	prometheusJobStatus            *prometheus.GaugeVec
+	prometheusJobLastSuccess       *prometheus.GaugeVec
 )
 
 func InitGlobalMetrics() {
@@ -58,6 +73,7 @@ func InitGlobalMetrics() {
 			"workspaceID",
 			"module",
 			"metric",
+			"reason",
 		},
 	)
 	prometheus.MustRegister(prometheusQueryStatus)
@@ -83,4 +99,118 @@ func InitGlobalMetrics() {
 		},
 	)
 	prometheus.MustRegister(prometheusQueryWorkspaceCount)
+
+	prometheusConcurrencyLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azure_loganalytics_concurrency_limit",
+			Help: "Azure loganalytics effective concurrency limit of the last request",
+		},
+		[]string{
+			"module",
+		},
+	)
+	prometheus.MustRegister(prometheusConcurrencyLimit)
+
+	prometheusConcurrencyWaitTime = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name: "azure_loganalytics_concurrency_wait_time",
+			Help: "Azure loganalytics time queries waited for a free concurrency slot",
+		},
+		[]string{
+			"module",
+			"metric",
+		},
+	)
+	prometheus.MustRegister(prometheusConcurrencyWaitTime)
+
+	prometheusQueryRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_loganalytics_query_retries",
+			Help: "Azure loganalytics query retry count",
+		},
+		[]string{
+			"workspaceID",
+			"module",
+			"metric",
+			"reason",
+		},
+	)
+	prometheus.MustRegister(prometheusQueryRetries)
+
+	prometheusSeriesDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_loganalytics_series_dropped",
+			Help: "Azure loganalytics series above the series limit (folded into \"other\" series or dropped)",
+		},
+		[]string{
+			"module",
+			"metric",
+		},
+	)
+	prometheus.MustRegister(prometheusSeriesDropped)
+
+	prometheusMetricErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_loganalytics_metric_errors",
+			Help: "Azure loganalytics series and exemplars which could not be exposed (skipped instead of failing the scrape)",
+		},
+		[]string{
+			"metric",
+		},
+	)
+	prometheus.MustRegister(prometheusMetricErrors)
+
+	prometheusValuesDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_loganalytics_values_dropped",
+			Help: "Azure loganalytics rows dropped because of null or non-numeric values (see nullValue and nonNumericValue) or incomplete bins (see timestampBin)",
+		},
+		[]string{
+			"module",
+			"metric",
+			"reason",
+		},
+	)
+	prometheus.MustRegister(prometheusValuesDropped)
+
+	prometheusJobStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azure_loganalytics_job_status",
+			Help: "Azure loganalytics scheduler job status of the last run (1 on success, 0 otherwise)",
+		},
+		[]string{
+			"job",
+			"module",
+		},
+	)
+	prometheus.MustRegister(prometheusJobStatus)
+
+	prometheusJobLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azure_loganalytics_job_last_success",
+			Help: "Azure loganalytics scheduler timestamp of the last successful job run (time of the served snapshot)",
+		},
+		[]string{
+			"job",
+			"module",
+		},
+	)
+	prometheus.MustRegister(prometheusJobLastSuccess)
+}
+
+// setQueryStatus sets the workspace status (1 on success, 0 otherwise) and removes series of previous reasons
+func setQueryStatus(moduleName, metricName, workspaceId, reason string) {
+	labels := prometheus.Labels{
+		"module":      moduleName,
+		"metric":      metricName,
+		"workspaceID": workspaceId,
+	}
+	prometheusQueryStatus.DeletePartialMatch(labels)
+
+	labels["reason"] = reason
+	if reason == QueryStatusSuccess {
+		prometheusQueryStatus.With(labels).Set(1)
+	} else {
+		prometheusQueryStatus.With(labels).Set(0)
+	}
 }
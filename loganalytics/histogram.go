@@ -0,0 +1,203 @@
+package loganalytics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+type (
+	// histogramBucket is one (non cumulative) bucket of a histogram
+	histogramBucket struct {
+		lowerBound float64
+		upperBound float64
+		count      float64
+		exemplar   prometheus.Labels
+	}
+
+	// histogramData contains the buckets, count and sum of one histogram
+	histogramData struct {
+		buckets []histogramBucket
+		count   float64
+		sum     float64
+	}
+
+	// nativeHistogramMetric is a classic histogram with additional native (sparse) buckets
+	nativeHistogramMetric struct {
+		prometheus.Metric
+		native prometheus.Metric
+	}
+)
+
+// newHistogramData builds the histogram from the grouped rows,
+// with binSize the bucket label values are bin() lower bounds instead of upper bounds
+func newHistogramData(group *metricGroup, metricMeta config.MetricMeta) *histogramData {
+	ret := &histogramData{}
+
+	bounds := []float64{}
+	for bound := range group.values {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	lowerBound := math.Inf(-1)
+	cumulativeCount := float64(0)
+	for _, bound := range bounds {
+		bucket := histogramBucket{
+			lowerBound: lowerBound,
+			upperBound: bound,
+			count:      group.values[bound],
+			exemplar:   group.exemplars[bound],
+		}
+
+		if metricMeta.BinSize > 0 {
+			bucket.lowerBound = bound
+			bucket.upperBound = bound + metricMeta.BinSize
+		}
+
+		if metricMeta.Cumulative {
+			bucket.count = math.Max(0, group.values[bound]-cumulativeCount)
+			cumulativeCount = math.Max(cumulativeCount, group.values[bound])
+		}
+
+		ret.buckets = append(ret.buckets, bucket)
+		ret.count += bucket.count
+		lowerBound = bucket.upperBound
+	}
+
+	// count row covers observations above the highest bucket
+	if group.count != nil && *group.count > ret.count {
+		ret.count = *group.count
+	}
+
+	switch {
+	case group.sum != nil:
+		ret.sum = *group.sum
+	case metricMeta.BinSize > 0:
+		// estimated by the middle of each bin
+		for _, bucket := range ret.buckets {
+			ret.sum += bucket.count * (bucket.lowerBound + metricMeta.BinSize/2)
+		}
+	}
+
+	return ret
+}
+
+// exemplars returns the exemplars of the buckets, the exemplar value is within the bucket
+// (middle of the bin or the upper bound)
+func (h *histogramData) exemplars() (ret []prometheus.Exemplar) {
+	for _, bucket := range h.buckets {
+		if bucket.exemplar == nil {
+			continue
+		}
+
+		value := bucket.upperBound
+		switch {
+		case !math.IsInf(bucket.lowerBound, 0) && !math.IsInf(bucket.upperBound, 0):
+			value = bucket.lowerBound + (bucket.upperBound-bucket.lowerBound)/2
+		case math.IsInf(bucket.upperBound, 1):
+			value = math.Nextafter(bucket.lowerBound, math.Inf(1))
+		}
+
+		ret = append(ret, prometheus.Exemplar{Value: value, Labels: bucket.exemplar})
+	}
+
+	return
+}
+
+// metric returns the histogram as classic histogram and optional with native buckets
+func (h *histogramData) metric(desc *prometheus.Desc, metricMeta config.MetricMeta, labelValues []string) (prometheus.Metric, error) {
+	buckets := map[float64]uint64{}
+	cumulativeCount := float64(0)
+	for _, bucket := range h.buckets {
+		cumulativeCount += bucket.count
+		// +Inf bucket is implicit (count)
+		if !math.IsInf(bucket.upperBound, 1) {
+			buckets[bucket.upperBound] = uint64(math.Round(cumulativeCount))
+		}
+	}
+
+	metric, err := prometheus.NewConstHistogram(desc, uint64(math.Round(h.count)), h.sum, buckets, labelValues...)
+	if err != nil || !metricMeta.NativeHistogram {
+		return metric, err
+	}
+
+	native, err := h.nativeMetric(desc, metricMeta.GetNativeHistogramSchema(), labelValues)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nativeHistogramMetric{Metric: metric, native: native}, nil
+}
+
+// nativeMetric maps the buckets to native histogram buckets (by their upper bound)
+func (h *histogramData) nativeMetric(desc *prometheus.Desc, schema int32, labelValues []string) (prometheus.Metric, error) {
+	positiveBuckets := map[int]int64{}
+	negativeBuckets := map[int]int64{}
+	zeroBucket := uint64(0)
+	maxIndex := math.MinInt
+
+	total := uint64(0)
+	for _, bucket := range h.buckets {
+		count := uint64(math.Round(bucket.count))
+		total += count
+
+		upperBound := bucket.upperBound
+		if math.IsInf(upperBound, 1) {
+			upperBound = math.MaxFloat64
+		}
+
+		switch {
+		case upperBound > 0:
+			index := nativeHistogramBucketIndex(upperBound, schema)
+			positiveBuckets[index] += int64(count) // #nosec G115
+			maxIndex = max(maxIndex, index)
+		case upperBound < 0:
+			negativeBuckets[nativeHistogramBucketIndex(-upperBound, schema)] += int64(count) // #nosec G115
+		default:
+			zeroBucket += count
+		}
+	}
+
+	// observations only known by count row are put above the highest bucket
+	count := uint64(math.Round(h.count))
+	if count > total {
+		overflowIndex := maxIndex + 1
+		if maxIndex == math.MinInt {
+			overflowIndex = 0
+		}
+		positiveBuckets[overflowIndex] += int64(count - total) // #nosec G115
+	}
+
+	return prometheus.NewConstNativeHistogram(desc, count, h.sum, positiveBuckets, negativeBuckets, zeroBucket, schema, 0, time.Time{}, labelValues...)
+}
+
+// nativeHistogramBucketIndex returns the index of the native histogram bucket containing the value
+func nativeHistogramBucketIndex(value float64, schema int32) int {
+	return int(math.Ceil(math.Log2(value) * math.Exp2(float64(schema))))
+}
+
+func (m *nativeHistogramMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+
+	native := &dto.Metric{}
+	if err := m.native.Write(native); err != nil {
+		return err
+	}
+
+	out.Histogram.Schema = native.Histogram.Schema
+	out.Histogram.ZeroThreshold = native.Histogram.ZeroThreshold
+	out.Histogram.ZeroCount = native.Histogram.ZeroCount
+	out.Histogram.PositiveSpan = native.Histogram.PositiveSpan
+	out.Histogram.PositiveDelta = native.Histogram.PositiveDelta
+	out.Histogram.NegativeSpan = native.Histogram.NegativeSpan
+	out.Histogram.NegativeDelta = native.Histogram.NegativeDelta
+	return nil
+}
@@ -0,0 +1,57 @@
+package loganalytics
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/webdevops/go-common/log/slogger"
+)
+
+// NewMetricsHandler returns a handler serving the gathered metrics in the negotiated exposition format,
+// OpenMetrics is supported including units and exemplars
+func NewMetricsHandler(logger *slogger.Logger, gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricFamilies, err := gatherer.Gather()
+		if err != nil {
+			// invalid metrics are skipped, serve the remaining metrics
+			var multiErr prometheus.MultiError
+			if !errors.As(err, &multiErr) {
+				multiErr = prometheus.MultiError{err}
+			}
+
+			for _, metricErr := range multiErr {
+				logger.Error("skipped metric: " + metricErr.Error())
+			}
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		w.Header().Set("Content-Type", string(format))
+
+		writer := io.Writer(w)
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gzipWriter := gzip.NewWriter(w)
+			defer gzipWriter.Close() // nolint:errcheck
+			writer = gzipWriter
+		}
+
+		encoder := expfmt.NewEncoder(writer, format, expfmt.WithUnit())
+		for _, metricFamily := range metricFamilies {
+			if err := encoder.Encode(metricFamily); err != nil {
+				logger.Error("error encoding metrics: " + err.Error())
+				return
+			}
+		}
+
+		if closer, ok := encoder.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Error("error encoding metrics: " + err.Error())
+			}
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package loganalytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HttpServiceDiscoveryLabelPrefix is the prefix of the workspace labels in the Prometheus http_sd response
+	HttpServiceDiscoveryLabelPrefix = "__meta_azure_loganalytics_"
+
+	httpServiceDiscoveryMetricsPath = "/probe/workspace"
+)
+
+type (
+	// httpServiceDiscoveryTargetGroup is one target group of the Prometheus http_sd format
+	httpServiceDiscoveryTargetGroup struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
+	}
+)
+
+// ServeHttpServiceDiscovery serves the discovered workspaces as Prometheus http_sd targets, every workspace is
+// a target scraping /probe/workspace of this exporter (address from parameter target or request host)
+func (sd *LogAnalyticsServiceDiscovery) ServeHttpServiceDiscovery(w http.ResponseWriter, r *http.Request) {
+	prober := sd.prober
+
+	sd.ServiceDiscovery()
+
+	targetAddress := strings.TrimSpace(prober.params.Get("target"))
+	if targetAddress == "" {
+		targetAddress = r.Host
+	}
+
+	targetGroups := []httpServiceDiscoveryTargetGroup{}
+	for _, workspaceConfig := range prober.workspaceList {
+		workspace := workspaceConfig.CustomerID
+		if workspaceConfig.ResourceID != "" {
+			// resource id is resolved by the probe for adding the workspace labels
+			workspace = workspaceConfig.ResourceID
+		}
+
+		labels := map[string]string{
+			"__metrics_path__":  httpServiceDiscoveryMetricsPath,
+			"__param_workspace": workspace,
+			"instance":          workspaceConfig.CustomerID,
+			HttpServiceDiscoveryLabelPrefix + "workspaceID": workspaceConfig.CustomerID,
+		}
+
+		if moduleName := prober.params.Get("module"); moduleName != "" {
+			labels["__param_module"] = moduleName
+		}
+
+		for labelName, labelValue := range workspaceConfig.Labels {
+			labels[HttpServiceDiscoveryLabelPrefix+labelName] = labelValue
+		}
+
+		targetGroups = append(targetGroups, httpServiceDiscoveryTargetGroup{
+			Targets: []string{targetAddress},
+			Labels:  labels,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(targetGroups); err != nil {
+		prober.logger.Error(err.Error())
+	}
+}
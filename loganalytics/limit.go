@@ -0,0 +1,227 @@
+package loganalytics
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+const (
+	// SeriesOverflowLabelValue is the label value of the series containing all series above the limit
+	SeriesOverflowLabelValue = "other"
+)
+
+type (
+	// seriesRows contains all rows of one series (histograms and summaries have multiple rows per series)
+	seriesRows struct {
+		rows  []kusto.MetricRow
+		score float64
+	}
+)
+
+// limitSeries limits the number of series of a metric, the top series (by value) are kept and all other series
+// are folded into one series with label value "other" (summaries are dropped as quantiles cannot be summed up),
+// returns the number of folded or dropped series
+func limitSeries(metricList *kusto.MetricList, metricName string, metricMeta config.MetricMeta, maxSeries int) int {
+	groupLabel := seriesGroupLabel(metricMeta)
+
+	seriesKeys := []string{}
+	series := map[string]*seriesRows{}
+	for _, row := range metricList.GetMetricList(metricName) {
+		seriesKey := seriesLabelKey(row, groupLabel)
+		if _, exists := series[seriesKey]; !exists {
+			series[seriesKey] = &seriesRows{}
+			seriesKeys = append(seriesKeys, seriesKey)
+		}
+
+		series[seriesKey].rows = append(series[seriesKey].rows, row)
+		if row.Value != nil && !math.IsNaN(*row.Value) {
+			series[seriesKey].score += *row.Value
+		}
+	}
+
+	if maxSeries <= 0 || len(seriesKeys) <= maxSeries {
+		return 0
+	}
+
+	sort.SliceStable(seriesKeys, func(i, j int) bool {
+		return series[seriesKeys[i]].score > series[seriesKeys[j]].score
+	})
+
+	// one series is reserved for the folded series
+	keepSeries := maxSeries - 1
+	if metricMeta.GetMetricType() == config.MetricTypeSummary {
+		keepSeries = maxSeries
+	}
+
+	rows := []kusto.MetricRow{}
+	overflowRows := []kusto.MetricRow{}
+	for num, seriesKey := range seriesKeys {
+		if num < keepSeries {
+			rows = append(rows, series[seriesKey].rows...)
+		} else {
+			overflowRows = append(overflowRows, series[seriesKey].rows...)
+		}
+	}
+
+	if metricMeta.GetMetricType() != config.MetricTypeSummary {
+		rows = append(rows, foldSeries(overflowRows, groupLabel)...)
+	}
+
+	metricList.List[metricName] = rows
+
+	return len(seriesKeys) - keepSeries
+}
+
+// seriesBudgetLimit returns the series limit per metric so the total number of series of all metrics stays within
+// the budget (metrics below the limit keep all series), at least one series is kept per metric
+func seriesBudgetLimit(seriesCounts []int, budget int) int {
+	total := func(limit int) int {
+		ret := 0
+		for _, count := range seriesCounts {
+			ret += min(count, limit)
+		}
+		return ret
+	}
+
+	maxCount := 0
+	for _, count := range seriesCounts {
+		maxCount = max(maxCount, count)
+	}
+
+	if total(maxCount) <= budget {
+		return maxCount
+	}
+
+	// largest limit within the budget
+	limit := 1
+	for low, high := 1, maxCount; low <= high; {
+		mid := (low + high) / 2
+		if total(mid) <= budget {
+			limit = mid
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+
+	return limit
+}
+
+// countSeries returns the number of series of a metric (rows of histograms and summaries and rows with different
+// timestamps belong to the same series)
+func countSeries(metricList *kusto.MetricList, metricName string, metricMeta config.MetricMeta) int {
+	groupLabel := seriesGroupLabel(metricMeta)
+
+	series := map[string]bool{}
+	for _, row := range metricList.GetMetricList(metricName) {
+		series[seriesLabelKey(row, groupLabel)] = true
+	}
+
+	return len(series)
+}
+
+// seriesGroupLabel returns the label which splits one series into multiple rows (histogram bucket, summary quantile)
+func seriesGroupLabel(metricMeta config.MetricMeta) string {
+	switch metricMeta.GetMetricType() {
+	case config.MetricTypeHistogram:
+		return metricMeta.GetBucketLabel()
+	case config.MetricTypeSummary:
+		return metricMeta.GetQuantileLabel()
+	}
+
+	return ""
+}
+
+// foldSeries sums up the rows into one series (one row per timestamp and group label value), labels with different
+// values are set to "other". Rows with timestamps are folded per timestamp (bin) and keep their timestamp.
+// The folded series of counters and histograms is not monotonic as its members change between scrapes
+// (unsafe for rate() and increase())
+func foldSeries(metricRows []kusto.MetricRow, groupLabel string) []kusto.MetricRow {
+	labels := prometheus.Labels{}
+	for num, row := range metricRows {
+		for labelName, labelValue := range row.Labels {
+			if isInternalLabel(labelName) || labelName == groupLabel {
+				continue
+			}
+
+			if existingValue, exists := labels[labelName]; (exists && existingValue != labelValue) || (!exists && num > 0) {
+				labelValue = SeriesOverflowLabelValue
+			}
+			labels[labelName] = labelValue
+		}
+	}
+
+	// labels missing in any row
+	for labelName := range labels {
+		for _, row := range metricRows {
+			if _, exists := row.Labels[labelName]; !exists {
+				labels[labelName] = SeriesOverflowLabelValue
+				break
+			}
+		}
+	}
+
+	type foldKey struct {
+		timestamp  string
+		groupValue string
+	}
+
+	foldKeys := []foldKey{}
+	values := map[foldKey]float64{}
+	for _, row := range metricRows {
+		if row.Value == nil {
+			continue
+		}
+
+		key := foldKey{timestamp: row.Labels[TimestampLabelName], groupValue: row.Labels[groupLabel]}
+		if _, exists := values[key]; !exists {
+			foldKeys = append(foldKeys, key)
+		}
+		values[key] += *row.Value
+	}
+
+	ret := []kusto.MetricRow{}
+	for _, key := range foldKeys {
+		row := kusto.MetricRow{Labels: prometheus.Labels{}}
+		for labelName, labelValue := range labels {
+			row.Labels[labelName] = labelValue
+		}
+
+		if groupLabel != "" {
+			row.Labels[groupLabel] = key.groupValue
+		}
+
+		if key.timestamp != "" {
+			row.Labels[TimestampLabelName] = key.timestamp
+		}
+
+		value := values[key]
+		row.Value = &value
+		ret = append(ret, row)
+	}
+
+	return ret
+}
+
+// seriesLabelKey returns the identity of the series of the row (all labels except group label and internal labels)
+func seriesLabelKey(row kusto.MetricRow, groupLabel string) string {
+	labelNames := []string{}
+	for labelName := range row.Labels {
+		if labelName != groupLabel && !isInternalLabel(labelName) {
+			labelNames = append(labelNames, labelName)
+		}
+	}
+	sort.Strings(labelNames)
+
+	parts := make([]string, len(labelNames))
+	for num, labelName := range labelNames {
+		parts[num] = labelName + "=" + row.Labels[labelName]
+	}
+	return strings.Join(parts, "\xff")
+}
@@ -0,0 +1,219 @@
+package loganalytics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+// newTestMetricRow returns a metric row with the labels (name=value pairs) and the value
+func newTestMetricRow(value float64, labels ...string) kusto.MetricRow {
+	row := kusto.MetricRow{Labels: prometheus.Labels{}, Value: &value}
+	for _, label := range labels {
+		labelName, labelValue, _ := strings.Cut(label, "=")
+		row.Labels[labelName] = labelValue
+	}
+	return row
+}
+
+// formatTestMetricRows returns the rows as sorted strings (labels sorted by name) for comparison
+func formatTestMetricRows(rows []kusto.MetricRow) []string {
+	ret := []string{}
+	for _, row := range rows {
+		labelNames := []string{}
+		for labelName := range row.Labels {
+			labelNames = append(labelNames, labelName)
+		}
+		sort.Strings(labelNames)
+
+		parts := []string{}
+		for _, labelName := range labelNames {
+			parts = append(parts, labelName+"="+row.Labels[labelName])
+		}
+
+		value := "nil"
+		if row.Value != nil {
+			value = strconv.FormatFloat(*row.Value, 'f', -1, 64)
+		}
+		ret = append(ret, "{"+strings.Join(parts, ",")+"} "+value)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func TestLimitSeries(t *testing.T) {
+	tests := []struct {
+		name        string
+		metricMeta  config.MetricMeta
+		maxSeries   int
+		rows        []kusto.MetricRow
+		wantDropped int
+		want        []string
+	}{
+		{
+			name:      "below limit",
+			maxSeries: 3,
+			rows: []kusto.MetricRow{
+				newTestMetricRow(1, "t=a"),
+				newTestMetricRow(2, "t=b"),
+			},
+			want: []string{"{t=a} 1", "{t=b} 2"},
+		},
+		{
+			name:      "unlimited",
+			maxSeries: 0,
+			rows: []kusto.MetricRow{
+				newTestMetricRow(1, "t=a"),
+				newTestMetricRow(2, "t=b"),
+			},
+			want: []string{"{t=a} 1", "{t=b} 2"},
+		},
+		{
+			name:      "gauge folded into other",
+			maxSeries: 2,
+			rows: []kusto.MetricRow{
+				newTestMetricRow(1, "t=a", "region=eu"),
+				newTestMetricRow(5, "t=b", "region=eu"),
+				newTestMetricRow(2, "t=c", "region=eu"),
+				newTestMetricRow(3, "t=d", "region=us"),
+			},
+			wantDropped: 3,
+			want:        []string{"{region=eu,t=b} 5", "{region=other,t=other} 6"},
+		},
+		{
+			name:      "common labels are kept",
+			maxSeries: 2,
+			rows: []kusto.MetricRow{
+				newTestMetricRow(1, "t=a", "region=eu"),
+				newTestMetricRow(5, "t=b", "region=eu"),
+				newTestMetricRow(2, "t=c", "region=eu"),
+			},
+			wantDropped: 2,
+			want:        []string{"{region=eu,t=b} 5", "{region=eu,t=other} 3"},
+		},
+		{
+			name:      "timestamped rows are folded per timestamp",
+			maxSeries: 2,
+			rows: []kusto.MetricRow{
+				newTestMetricRow(100, "t=a", "__timestamp=1000"),
+				newTestMetricRow(200, "t=a", "__timestamp=2000"),
+				newTestMetricRow(10, "t=b", "__timestamp=1000"),
+				newTestMetricRow(20, "t=b", "__timestamp=2000"),
+				newTestMetricRow(30, "t=c", "__timestamp=1000"),
+				newTestMetricRow(40, "t=c", "__timestamp=2000"),
+			},
+			wantDropped: 2,
+			want: []string{
+				"{__timestamp=1000,t=a} 100",
+				"{__timestamp=1000,t=other} 40",
+				"{__timestamp=2000,t=a} 200",
+				"{__timestamp=2000,t=other} 60",
+			},
+		},
+		{
+			name:       "histogram folded per bucket",
+			metricMeta: config.MetricMeta{MetricType: config.MetricTypeHistogram},
+			maxSeries:  2,
+			rows: []kusto.MetricRow{
+				newTestMetricRow(10, "t=a", "le=1"),
+				newTestMetricRow(20, "t=a", "le=+Inf"),
+				newTestMetricRow(1, "t=b", "le=1"),
+				newTestMetricRow(2, "t=b", "le=+Inf"),
+				newTestMetricRow(3, "t=c", "le=1"),
+				newTestMetricRow(4, "t=c", "le=+Inf"),
+			},
+			wantDropped: 2,
+			want: []string{
+				"{le=+Inf,t=a} 20",
+				"{le=+Inf,t=other} 6",
+				"{le=1,t=a} 10",
+				"{le=1,t=other} 4",
+			},
+		},
+		{
+			name:       "summary series are dropped",
+			metricMeta: config.MetricMeta{MetricType: config.MetricTypeSummary},
+			maxSeries:  1,
+			rows: []kusto.MetricRow{
+				newTestMetricRow(1, "t=a", "quantile=0.5"),
+				newTestMetricRow(5, "t=b", "quantile=0.5"),
+			},
+			wantDropped: 1,
+			want:        []string{"{quantile=0.5,t=b} 5"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricList := &kusto.MetricList{}
+			metricList.Init()
+			metricList.Add("rows", test.rows...)
+
+			dropped := limitSeries(metricList, "rows", test.metricMeta, test.maxSeries)
+			if dropped != test.wantDropped {
+				t.Errorf("got %v dropped series, want %v", dropped, test.wantDropped)
+			}
+
+			got := formatTestMetricRows(metricList.GetMetricList("rows"))
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("got rows\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestSeriesBudgetLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		seriesCounts []int
+		budget       int
+		want         int
+	}{
+		{name: "within budget", seriesCounts: []int{3, 5}, budget: 10, want: 5},
+		{name: "exact budget", seriesCounts: []int{3, 5}, budget: 8, want: 5},
+		{name: "largest metric is limited", seriesCounts: []int{3, 100}, budget: 10, want: 7},
+		{name: "all metrics are limited", seriesCounts: []int{50, 100, 200}, budget: 30, want: 10},
+		{name: "small metrics keep their series", seriesCounts: []int{1, 2, 100}, budget: 10, want: 7},
+		{name: "budget below metric count", seriesCounts: []int{10, 10, 10}, budget: 2, want: 1},
+		{name: "no metrics", seriesCounts: []int{}, budget: 10, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := seriesBudgetLimit(test.seriesCounts, test.budget); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCountSeries(t *testing.T) {
+	metricList := &kusto.MetricList{}
+	metricList.Init()
+	metricList.Add("rows",
+		newTestMetricRow(1, "t=a", "__timestamp=1000"),
+		newTestMetricRow(2, "t=a", "__timestamp=2000"),
+		newTestMetricRow(3, "t=b", "__timestamp=1000"),
+	)
+	metricList.Add("latency",
+		newTestMetricRow(1, "t=a", "le=1"),
+		newTestMetricRow(2, "t=a", "le=+Inf"),
+		newTestMetricRow(3, "t=b", "le=1"),
+		newTestMetricRow(4, "t=b", "le=+Inf"),
+		newTestMetricRow(5, "t=c", "le=+Inf"),
+	)
+
+	if got := countSeries(metricList, "rows", config.MetricMeta{}); got != 2 {
+		t.Errorf("got %v series for rows, want 2", got)
+	}
+
+	if got := countSeries(metricList, "latency", config.MetricMeta{MetricType: config.MetricTypeHistogram}); got != 3 {
+		t.Errorf("got %v series for latency, want 3", got)
+	}
+}
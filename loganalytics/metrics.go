@@ -0,0 +1,283 @@
+package loganalytics
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/webdevops/go-common/log/slogger"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+type (
+	// metricListCollector exposes the metric list as prometheus metrics using the configured metric types
+	metricListCollector struct {
+		logger      *slogger.Logger
+		metricList  *kusto.MetricList
+		queryConfig *config.QueryConfig
+
+		// backfill exposes samples with their timestamp regardless of age and previously exposed samples
+		backfill bool
+	}
+
+	// metricGroup collects the rows of one histogram or summary (all rows with same labels except bucket/quantile label)
+	metricGroup struct {
+		labelValues []string
+		values      map[float64]float64
+		exemplars   map[float64]prometheus.Labels
+		sum         *float64
+		count       *float64
+		timestamp   *time.Time
+	}
+
+	// metricFamilyGatherer adds the settings which are not supported by the client library (unit) to the gathered metric families
+	metricFamilyGatherer struct {
+		prometheus.Gatherer
+		queryConfig *config.QueryConfig
+	}
+
+	// metricSample is one sample of a gauge or counter
+	metricSample struct {
+		labelValues []string
+		value       float64
+		timestamp   *time.Time
+		exemplar    prometheus.Labels
+	}
+)
+
+// buildPrometheusMetrics registers all metrics from the metric list into the registry
+func buildPrometheusMetrics(logger *slogger.Logger, registry *prometheus.Registry, metricList *kusto.MetricList, queryConfig *config.QueryConfig) {
+	registry.MustRegister(&metricListCollector{
+		logger:      logger,
+		metricList:  metricList,
+		queryConfig: queryConfig,
+	})
+}
+
+// Describe sends no descriptors, label sets depend on query results (unchecked collector)
+func (c *metricListCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *metricListCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metricName := range c.metricList.GetMetricNames() {
+		metricMeta := c.queryConfig.GetMetricMeta(metricName)
+
+		switch metricMeta.GetMetricType() {
+		case config.MetricTypeHistogram:
+			c.collectHistogram(ch, metricName, metricMeta)
+		case config.MetricTypeSummary:
+			c.collectSummary(ch, metricName, metricMeta)
+		case config.MetricTypeCounter:
+			c.collectValue(ch, metricName, metricMeta, prometheus.CounterValue)
+		default:
+			c.collectValue(ch, metricName, metricMeta, prometheus.GaugeValue)
+		}
+	}
+}
+
+// collectValue sends gauge or counter metrics, rows with same labels are overwritten by later rows
+// (or by rows with newer timestamp if timestamps are set)
+func (c *metricListCollector) collectValue(ch chan<- prometheus.Metric, metricName string, metricMeta config.MetricMeta, valueType prometheus.ValueType) {
+	labelNames := c.labelNames(metricName, "")
+	desc := prometheus.NewDesc(metricName, metricMeta.GetHelp(metricName), labelNames, nil)
+
+	metricKeys := []string{}
+	samples := map[string]*metricSample{}
+	for _, row := range c.metricList.GetMetricList(metricName) {
+		if row.Value == nil {
+			continue
+		}
+
+		sample := &metricSample{
+			labelValues: rowLabelValues(row, labelNames),
+			value:       *row.Value,
+			timestamp:   rowTimestamp(row),
+			exemplar:    rowExemplarLabels(row),
+		}
+
+		metricKey := strings.Join(sample.labelValues, "\xff")
+		if existing, exists := samples[metricKey]; !exists {
+			metricKeys = append(metricKeys, metricKey)
+		} else if existing.timestamp != nil && sample.timestamp != nil && sample.timestamp.Before(*existing.timestamp) {
+			continue
+		}
+		samples[metricKey] = sample
+	}
+
+	for _, metricKey := range metricKeys {
+		sample := samples[metricKey]
+		metric, err := prometheus.NewConstMetric(desc, valueType, sample.value, sample.labelValues...)
+		if err != nil {
+			metric = invalidMetric(desc, metricName, err)
+		} else if valueType == prometheus.CounterValue && sample.exemplar != nil {
+			metric = c.withExemplars(metric, metricName, sample.timestamp, prometheus.Exemplar{Value: sample.value, Labels: sample.exemplar})
+		}
+		c.send(ch, metric, metricName, metricMeta, labelNames, sample.labelValues, sample.timestamp)
+	}
+}
+
+// collectHistogram sends histograms, bucket bounds are taken from the bucket label,
+// rows with bucket label "sum" and "count" are used for _sum and _count
+func (c *metricListCollector) collectHistogram(ch chan<- prometheus.Metric, metricName string, metricMeta config.MetricMeta) {
+	bucketLabel := metricMeta.GetBucketLabel()
+	labelNames := c.labelNames(metricName, bucketLabel)
+	desc := prometheus.NewDesc(metricName, metricMeta.GetHelp(metricName), labelNames, nil)
+
+	for _, group := range c.groupRows(metricName, bucketLabel, labelNames) {
+		histogram := newHistogramData(group, metricMeta)
+		metric, err := histogram.metric(desc, metricMeta, group.labelValues)
+		if err != nil {
+			metric = invalidMetric(desc, metricName, err)
+		} else {
+			metric = c.withExemplars(metric, metricName, group.timestamp, histogram.exemplars()...)
+		}
+		c.send(ch, metric, metricName, metricMeta, labelNames, group.labelValues, group.timestamp)
+	}
+}
+
+// collectSummary sends summaries, quantiles are taken from the quantile label (percentiles > 1 are converted),
+// rows with quantile label "sum" and "count" are used for _sum and _count
+func (c *metricListCollector) collectSummary(ch chan<- prometheus.Metric, metricName string, metricMeta config.MetricMeta) {
+	quantileLabel := metricMeta.GetQuantileLabel()
+	labelNames := c.labelNames(metricName, quantileLabel)
+	desc := prometheus.NewDesc(metricName, metricMeta.GetHelp(metricName), labelNames, nil)
+
+	for _, group := range c.groupRows(metricName, quantileLabel, labelNames) {
+		quantiles := map[float64]float64{}
+		for quantile, value := range group.values {
+			if quantile > 1 {
+				// percentile (eg. 95) instead of quantile (0.95)
+				quantile = quantile / 100
+			}
+			quantiles[quantile] = value
+		}
+
+		count := float64(0)
+		if group.count != nil {
+			count = *group.count
+		}
+
+		sum := float64(0)
+		if group.sum != nil {
+			sum = *group.sum
+		}
+
+		metric, err := prometheus.NewConstSummary(desc, uint64(count), sum, quantiles, group.labelValues...)
+		if err != nil {
+			metric = invalidMetric(desc, metricName, err)
+		}
+		c.send(ch, metric, metricName, metricMeta, labelNames, group.labelValues, group.timestamp)
+	}
+}
+
+// groupRows groups all rows of a metric by their labels (except the group label) and parses the group label value
+func (c *metricListCollector) groupRows(metricName, groupLabel string, labelNames []string) []*metricGroup {
+	groupKeys := []string{}
+	groups := map[string]*metricGroup{}
+
+	for _, row := range c.metricList.GetMetricList(metricName) {
+		if row.Value == nil {
+			continue
+		}
+
+		labelValues := rowLabelValues(row, labelNames)
+		groupKey := strings.Join(labelValues, "\xff")
+		group, exists := groups[groupKey]
+		if !exists {
+			group = &metricGroup{labelValues: labelValues, values: map[float64]float64{}, exemplars: map[float64]prometheus.Labels{}}
+			groups[groupKey] = group
+			groupKeys = append(groupKeys, groupKey)
+		}
+
+		// group uses the newest timestamp of its rows
+		if timestamp := rowTimestamp(row); timestamp != nil && (group.timestamp == nil || timestamp.After(*group.timestamp)) {
+			group.timestamp = timestamp
+		}
+
+		value := *row.Value
+		switch groupLabelValue := strings.TrimSpace(row.Labels[groupLabel]); strings.ToLower(groupLabelValue) {
+		case "sum":
+			group.sum = &value
+		case "count":
+			group.count = &value
+		default:
+			if bound, err := strconv.ParseFloat(groupLabelValue, 64); err == nil {
+				group.values[bound] = value
+				if exemplar := rowExemplarLabels(row); exemplar != nil {
+					group.exemplars[bound] = exemplar
+				}
+			}
+		}
+	}
+
+	ret := []*metricGroup{}
+	for _, groupKey := range groupKeys {
+		ret = append(ret, groups[groupKey])
+	}
+	return ret
+}
+
+// send sends the metric, samples with timestamp are sent with explicit timestamp
+// or dropped if the timestamp is too old or out-of-order (not checked for backfill)
+func (c *metricListCollector) send(ch chan<- prometheus.Metric, metric prometheus.Metric, metricName string, metricMeta config.MetricMeta, labelNames, labelValues []string, timestamp *time.Time) {
+	if timestamp != nil {
+		sampleTimestamp := *timestamp
+		if !c.backfill {
+			var ok bool
+			sampleTimestamp, ok = sampleTimestamps.checkTimestamp(metricName, labelNames, labelValues, sampleTimestamp, metricMeta.GetTimestampMaxAge())
+			if !ok {
+				return
+			}
+		}
+		metric = prometheus.NewMetricWithTimestamp(sampleTimestamp, metric)
+	}
+
+	ch <- metric
+}
+
+// labelNames returns the sorted label names of a metric without the excluded label and internal labels
+func (c *metricListCollector) labelNames(metricName, excludeLabel string) []string {
+	labelNames := slices.DeleteFunc(c.metricList.GetMetricLabelNames(metricName), func(labelName string) bool {
+		return (excludeLabel != "" && labelName == excludeLabel) || isInternalLabel(labelName)
+	})
+	sort.Strings(labelNames)
+	return labelNames
+}
+
+// invalidMetric returns a metric which fails on gathering, only the affected series is skipped
+func invalidMetric(desc *prometheus.Desc, metricName string, err error) prometheus.Metric {
+	prometheusMetricErrors.With(prometheus.Labels{"metric": metricName}).Inc()
+	return prometheus.NewInvalidMetric(desc, fmt.Errorf("metric \"%s\": %w", metricName, err))
+}
+
+// rowLabelValues returns the label values of the row, missing labels are empty
+func rowLabelValues(row kusto.MetricRow, labelNames []string) []string {
+	labelValues := make([]string, len(labelNames))
+	for num, labelName := range labelNames {
+		labelValues[num] = row.Labels[labelName]
+	}
+	return labelValues
+}
+
+func newMetricFamilyGatherer(gatherer prometheus.Gatherer, queryConfig *config.QueryConfig) prometheus.Gatherer {
+	return &metricFamilyGatherer{Gatherer: gatherer, queryConfig: queryConfig}
+}
+
+func (g *metricFamilyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	metricFamilies, err := g.Gatherer.Gather()
+
+	for _, metricFamily := range metricFamilies {
+		if metricMeta := g.queryConfig.GetMetricMeta(metricFamily.GetName()); metricMeta.Unit != "" {
+			unit := metricMeta.Unit
+			metricFamily.Unit = &unit
+		}
+	}
+
+	return metricFamilies, err
+}
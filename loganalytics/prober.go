@@ -3,10 +3,14 @@ package loganalytics
 import (
 	"context"
 	"crypto/sha1" // #nosec
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,7 +18,6 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
 	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/remeh/sizedwaitgroup"
 	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/log/slogger"
@@ -30,7 +33,7 @@ const (
 
 type (
 	LogAnalyticsProber struct {
-		QueryConfig kusto.Config
+		QueryConfig config.QueryConfig
 		Conf        config.Opts
 		UserAgent   string
 
@@ -42,10 +45,15 @@ type (
 
 		workspaceList []WorkspaceConfig
 
-		request  *http.Request
-		response http.ResponseWriter
+		params url.Values
+		// trustedParams is set for jobs, params are from trusted config (eg. raw workspace filter is allowed)
+		trustedParams bool
+		request       *http.Request
+		response      http.ResponseWriter
 
 		ctx context.Context
+		// queryCtx is used for workspace queries and additionally bound to the scrape deadline
+		queryCtx context.Context
 
 		registry   *prometheus.Registry
 		metricList *kusto.MetricList
@@ -54,17 +62,22 @@ type (
 
 		cache *cache.Cache
 
+		logsClientPool *LogsClientPool
+
 		config struct {
 			moduleName    string
 			optional      bool
 			cacheEnabled  bool
 			cacheDuration *time.Duration
+			cacheMaxStale time.Duration
 			cacheKey      *string
+			maxSeries     int
 		}
 
 		ServiceDiscovery LogAnalyticsServiceDiscovery
 
-		concurrencyWaitGroup *sizedwaitgroup.SizedWaitGroup
+		concurrencyWaitGroup        *sizedwaitgroup.SizedWaitGroup
+		requestConcurrencyWaitGroup *sizedwaitgroup.SizedWaitGroup
 	}
 
 	WorkspaceConfig struct {
@@ -83,15 +96,49 @@ type (
 	LogAnalyticsPanicStop struct {
 		Message string
 	}
+
+	// LogAnalyticsQueryError is returned if a failed query should fail the whole probe (onError: fail)
+	LogAnalyticsQueryError struct {
+		Metric      string
+		WorkspaceId string
+		Err         error
+	}
 )
 
+func (e *LogAnalyticsQueryError) Error() string {
+	return fmt.Sprintf("query \"%s\" failed for workspace \"%s\": %v", e.Metric, e.WorkspaceId, e.Err)
+}
+
+func (e *LogAnalyticsQueryError) Unwrap() error {
+	return e.Err
+}
+
+// NewLogAnalyticsProber creates a prober for a http probe request, parameters are taken from the request url
 func NewLogAnalyticsProber(logger *slogger.Logger, w http.ResponseWriter, r *http.Request, concurrencyWaitGroup *sizedwaitgroup.SizedWaitGroup) *LogAnalyticsProber {
+	prober := newLogAnalyticsProber(r.Context(), logger, r.URL.Query(), concurrencyWaitGroup)
+	prober.request = r
+	prober.response = w
+	prober.Init()
+
+	return prober
+}
+
+// NewLogAnalyticsJobProber creates a prober without http request (eg. for background jobs), parameters are passed as url.Values
+func NewLogAnalyticsJobProber(ctx context.Context, logger *slogger.Logger, params url.Values, concurrencyWaitGroup *sizedwaitgroup.SizedWaitGroup) *LogAnalyticsProber {
+	prober := newLogAnalyticsProber(ctx, logger, params, concurrencyWaitGroup)
+	prober.trustedParams = true
+	prober.Init()
+
+	return prober
+}
+
+func newLogAnalyticsProber(ctx context.Context, logger *slogger.Logger, params url.Values, concurrencyWaitGroup *sizedwaitgroup.SizedWaitGroup) *LogAnalyticsProber {
 	prober := LogAnalyticsProber{}
 	prober.logger = logger
 	prober.workspaceList = []WorkspaceConfig{}
-	prober.request = r
-	prober.response = w
-	prober.ctx = context.Background()
+	prober.params = params
+	prober.ctx = ctx
+	prober.queryCtx = ctx
 	prober.registry = prometheus.NewRegistry()
 	prober.concurrencyWaitGroup = concurrencyWaitGroup
 
@@ -102,35 +149,72 @@ func NewLogAnalyticsProber(logger *slogger.Logger, w http.ResponseWriter, r *htt
 		prober: &prober,
 	}
 
-	prober.Init()
-
 	return &prober
 }
 
 func (p *LogAnalyticsProber) Init() {
-	p.config.moduleName = p.request.URL.Query().Get("module")
-	p.config.optional = p.request.URL.Query().Get("optional") == "true"
+	p.config.moduleName = p.params.Get("module")
+	p.config.optional = p.params.Get("optional") == "true"
 
 	p.logger = p.logger.With(slog.String("module", p.config.moduleName))
 
-	cacheTime, err := p.parseCacheTime(p.request)
+	cacheTime, err := p.parseCacheTime(p.params)
 	if err != nil {
 		p.logger.Error(err.Error())
 		panic(LogAnalyticsPanicStop{Message: err.Error()})
 	}
 
+	parallel, err := p.parseParallel(p.params)
+	if err != nil {
+		p.logger.Error(err.Error())
+		panic(LogAnalyticsPanicStop{Message: err.Error()})
+	}
+
+	p.config.maxSeries, err = p.parseMaxSeries(p.params)
+	if err != nil {
+		p.logger.Error(err.Error())
+		panic(LogAnalyticsPanicStop{Message: err.Error()})
+	}
+
+	requestConcurrencyWaitGroup := sizedwaitgroup.New(parallel)
+	p.requestConcurrencyWaitGroup = &requestConcurrencyWaitGroup
+	prometheusConcurrencyLimit.With(prometheus.Labels{"module": p.config.moduleName}).Set(float64(parallel))
+
 	if cacheTime.Seconds() > 0 {
+		cacheMaxStale, err := p.parseCacheMaxStale(p.params)
+		if err != nil {
+			p.logger.Error(err.Error())
+			panic(LogAnalyticsPanicStop{Message: err.Error()})
+		}
+
 		p.config.cacheEnabled = true
 		p.config.cacheDuration = &cacheTime
+		p.config.cacheMaxStale = cacheMaxStale
 		p.config.cacheKey = to.StringPtr(
 			fmt.Sprintf(
 				"metrics:%x",
-				string(sha1.New().Sum([]byte(p.request.RequestURI))), //nolint:gosec
+				string(sha1.New().Sum([]byte(p.requestIdentifier()))), //nolint:gosec
 			),
 		)
 	}
 }
 
+// requestIdentifier returns the request uri or, for probers without request, the encoded parameters
+func (p *LogAnalyticsProber) requestIdentifier() string {
+	if p.request != nil {
+		return p.request.RequestURI
+	}
+
+	return "job:" + p.params.Encode()
+}
+
+// addResponseHeader adds a header to the http response (if prober is serving a http request)
+func (p *LogAnalyticsProber) addResponseHeader(name, value string) {
+	if p.response != nil {
+		p.response.Header().Add(name, value)
+	}
+}
+
 func (p *LogAnalyticsProber) SetAzureClient(client *armclient.ArmClient) {
 	p.Azure.Client = client
 
@@ -146,6 +230,10 @@ func (p *LogAnalyticsProber) EnableCache(cache *cache.Cache) {
 	p.cache = cache
 }
 
+func (p *LogAnalyticsProber) EnableLogsClientPool(pool *LogsClientPool) {
+	p.logsClientPool = pool
+}
+
 func (p *LogAnalyticsProber) SetPrometheusRegistry(registry *prometheus.Registry) {
 	p.registry = registry
 }
@@ -154,6 +242,10 @@ func (p *LogAnalyticsProber) GetPrometheusRegistry() *prometheus.Registry {
 	return p.registry
 }
 
+func (p *LogAnalyticsProber) GetMetricList() *kusto.MetricList {
+	return p.metricList
+}
+
 func (p *LogAnalyticsProber) translateWorkspaceIntoConfig(val string) WorkspaceConfig {
 	workspaceConfig := WorkspaceConfig{
 		Labels: map[string]string{},
@@ -197,85 +289,87 @@ func (p *LogAnalyticsProber) AddWorkspaces(workspaces ...string) {
 	}
 }
 
+// Run collects the metrics and serves them as http response
 func (p *LogAnalyticsProber) Run() {
 	requestTime := time.Now()
 
-	// check if value is cached
-	executeQuery := true
-	if p.cache != nil && p.config.cacheEnabled {
-		if v, ok := p.cache.Get(*p.config.cacheKey); ok {
-			if cacheData, ok := v.([]byte); ok {
-				if err := json.Unmarshal(cacheData, &p.metricList); err == nil {
-					p.logger.Debug("fetched metrics from cache")
-					p.response.Header().Add("X-metrics-cached", "true")
-					executeQuery = false
-				} else {
-					p.logger.Debug("unable to parse cached metrics")
-				}
-			}
+	if err := p.Collect(); err != nil {
+		p.logger.With(slog.String("request", p.requestIdentifier())).Error(err.Error())
+
+		// failed queries are upstream errors, everything else is caused by the request
+		var queryErr *LogAnalyticsQueryError
+		if errors.As(err, &queryErr) {
+			p.response.WriteHeader(http.StatusBadGateway)
+		} else {
+			p.response.WriteHeader(http.StatusBadRequest)
 		}
+		if _, writeErr := p.response.Write([]byte("ERROR: " + err.Error())); writeErr != nil {
+			p.logger.Error(writeErr.Error())
+		}
+		return
 	}
 
-	if executeQuery {
-		p.response.Header().Add("X-metrics-cached", "false")
+	p.logger.Debug("building prometheus metrics")
+	buildPrometheusMetrics(p.logger, p.registry, p.metricList, &p.QueryConfig)
+	p.logger.With(slog.Duration("duration", time.Since(requestTime))).Debug("finished request")
 
-		if p.ServiceDiscovery.enabled {
-			p.ServiceDiscovery.ServiceDiscovery()
-		}
+	h := NewMetricsHandler(p.logger, newMetricFamilyGatherer(p.GetPrometheusRegistry(), &p.QueryConfig))
+	h.ServeHTTP(p.response, p.request)
+}
 
-		prometheusQueryWorkspaceCount.With(prometheus.Labels{"module": p.config.moduleName}).Set(float64(len(p.workspaceList)))
+// Collect executes the queries (or fetches the metrics from cache) and stores the result in the metric list
+func (p *LogAnalyticsProber) Collect() error {
+	// check if value is cached
+	if p.fetchFromCache() {
+		return nil
+	}
 
-		if p.config.optional && len(p.workspaceList) == 0 {
-			return
-		}
+	p.addResponseHeader("X-metrics-cached", "false")
 
-		err := p.executeQueries()
-		if err != nil {
-			p.logger.With(slog.String("request", p.request.RequestURI)).Error(err.Error())
-			p.response.WriteHeader(http.StatusBadRequest)
-			if _, writeErr := p.response.Write([]byte("ERROR: " + err.Error())); writeErr != nil {
-				p.logger.Error(writeErr.Error())
-			}
-			return
-		}
+	return p.collect()
+}
 
-		// store to cache (if enabeld)
-		if p.cache != nil && p.config.cacheEnabled {
-			p.logger.Debug("saving metrics to cache")
-			if cacheData, err := json.Marshal(p.metricList); err == nil {
-				p.response.Header().Add("X-metrics-cached-until", time.Now().Add(*p.config.cacheDuration).Format(time.RFC3339))
-				p.cache.Set(*p.config.cacheKey, cacheData, *p.config.cacheDuration)
-				p.logger.Debugf("saved metric to cache for %s", p.config.cacheDuration.String())
-			}
-		}
+// collect executes the queries and stores the result in the metric list and cache
+func (p *LogAnalyticsProber) collect() error {
+	if deadline := p.scrapeDeadline(); deadline != nil {
+		var cancel context.CancelFunc
+		p.queryCtx, cancel = context.WithDeadline(p.ctx, *deadline)
+		defer cancel()
 	}
 
-	p.logger.Debug("building prometheus metrics")
-	for _, metricName := range p.metricList.GetMetricNames() {
-		metricLabelNames := p.metricList.GetMetricLabelNames(metricName)
-
-		gaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: metricName,
-			Help: metricName,
-		}, metricLabelNames)
-		p.registry.MustRegister(gaugeVec)
-
-		for _, metric := range p.metricList.GetMetricList(metricName) {
-			for _, labelName := range metricLabelNames {
-				if _, ok := metric.Labels[labelName]; !ok {
-					metric.Labels[labelName] = ""
-				}
-			}
+	if p.ServiceDiscovery.enabled {
+		p.ServiceDiscovery.ServiceDiscovery()
+	}
 
-			if metric.Value != nil {
-				gaugeVec.With(metric.Labels).Set(*metric.Value)
-			}
-		}
+	prometheusQueryWorkspaceCount.With(prometheus.Labels{"module": p.config.moduleName}).Set(float64(len(p.workspaceList)))
+
+	if p.config.optional && len(p.workspaceList) == 0 {
+		return nil
 	}
-	p.logger.With(slog.Duration("duration", time.Since(requestTime))).Debug("finished request")
 
-	h := promhttp.HandlerFor(p.GetPrometheusRegistry(), promhttp.HandlerOpts{})
-	h.ServeHTTP(p.response, p.request)
+	if err := p.executeQueries(); err != nil {
+		return err
+	}
+
+	p.buildDerivedMetrics()
+	p.limitProbeSeries()
+
+	// request was cancelled (eg. client disconnected), results are incomplete
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+
+	// scrape deadline reached, serve partial results but don't cache them
+	if p.queryCtx.Err() != nil {
+		p.logger.Warn("scrape deadline reached, serving partial results")
+		p.addResponseHeader("X-metrics-partial", "true")
+		return nil
+	}
+
+	// store to cache (if enabeld)
+	p.storeToCache()
+
+	return nil
 }
 
 func (p *LogAnalyticsProber) executeQueries() error {
@@ -314,29 +408,55 @@ func (p *LogAnalyticsProber) executeQueries() error {
 		go func() {
 			switch strings.ToLower(queryRow.QueryMode) {
 			case "all", "multi":
-				wgProbes.Add(1)
-				p.concurrencyWaitGroup.Add()
-				go func() {
-					defer wgProbes.Done()
-					defer p.concurrencyWaitGroup.Done()
-					p.sendQueryToMultipleWorkspace(
-						queryLogger,
-						workspaceList,
-						queryConfig,
-						resultChannel,
-					)
-				}()
+				// split workspaces into batches as cross workspace queries are limited
+				batchSize := queryConfig.GetBatchSize(p.Conf.Loganalytics.BatchSize)
+				if batchSize <= 0 {
+					// batching disabled
+					batchSize = max(len(workspaceList), 1)
+				}
+
+				for workspaceBatch := range slices.Chunk(workspaceList, batchSize) {
+					releaseConcurrencySlot, err := p.acquireConcurrencySlot(queryConfig)
+					if err != nil {
+						resultChannel <- LogAnalyticsProbeResult{
+							Error: err,
+						}
+						continue
+					}
+
+					wgProbes.Add(1)
+					go func() {
+						defer wgProbes.Done()
+						defer releaseConcurrencySlot()
+						p.sendQueryToMultipleWorkspace(
+							queryLogger,
+							workspaceBatch,
+							queryConfig,
+							resultChannel,
+						)
+					}()
+				}
 			case "", "single":
 				for _, row := range workspaceList {
 					workspaceConfig := row
+
+					releaseConcurrencySlot, err := p.acquireConcurrencySlot(queryConfig)
+					if err != nil {
+						// request was cancelled or deadline reached, report workspace without starting query
+						resultChannel <- LogAnalyticsProbeResult{
+							WorkspaceId: workspaceConfig.CustomerID,
+							Error:       err,
+						}
+						continue
+					}
+
 					// Run the query and get the results
 					prometheusQueryRequests.With(prometheus.Labels{"workspaceID": workspaceConfig.CustomerID, "module": p.config.moduleName, "metric": queryConfig.Metric}).Inc()
 
 					wgProbes.Add(1)
-					p.concurrencyWaitGroup.Add()
 					go func() {
 						defer wgProbes.Done()
-						defer p.concurrencyWaitGroup.Done()
+						defer releaseConcurrencySlot()
 						p.sendQueryToSingleWorkspace(
 							queryLogger,
 							workspaceConfig,
@@ -357,45 +477,179 @@ func (p *LogAnalyticsProber) executeQueries() error {
 			close(resultChannel)
 		}()
 
+		onErrorPolicy := queryConfig.GetOnError()
+		workspaceResults := map[string][]LogAnalyticsProbeResult{}
+		workspaceErrors := map[string]error{}
+
+		// results are collected per query for applying the series limit
+		queryMetricList := &kusto.MetricList{}
+		queryMetricList.Init()
+
+		// status is set once per workspace after all results are processed, errors take precedence
+		workspaceStatus := map[string]string{}
+
 		for result := range resultChannel {
 			if result.Error == nil {
-				resultTotalRecords++
-				p.metricList.Add(result.Name, result.Metrics...)
+				// results without metrics only report workspace status
+				if len(result.Metrics) > 0 {
+					resultTotalRecords++
+					queryMetricList.Add(result.Name, result.Metrics...)
+				}
 
-				prometheusQueryStatus.With(prometheus.Labels{
-					"module":      p.config.moduleName,
-					"metric":      queryConfig.Metric,
-					"workspaceID": result.WorkspaceId,
-				}).Set(1)
+				if onErrorPolicy == config.QueryOnErrorStale {
+					workspaceResults[result.WorkspaceId] = append(workspaceResults[result.WorkspaceId], result)
+				}
+
+				if _, exists := workspaceStatus[result.WorkspaceId]; !exists {
+					workspaceStatus[result.WorkspaceId] = QueryStatusSuccess
+				}
+			} else {
+				if errors.Is(result.Error, context.DeadlineExceeded) {
+					workspaceStatus[result.WorkspaceId] = QueryStatusTimeout
+				} else {
+					workspaceStatus[result.WorkspaceId] = QueryStatusError
+				}
 
+				queryLogger.Error(result.Error.Error())
+				workspaceErrors[result.WorkspaceId] = result.Error
+			}
+		}
+
+		var queryErr error
+		switch onErrorPolicy {
+		case config.QueryOnErrorFail:
+			for workspaceId, err := range workspaceErrors {
+				queryErr = &LogAnalyticsQueryError{Metric: queryConfig.Metric, WorkspaceId: workspaceId, Err: err}
+				break
+			}
+		case config.QueryOnErrorStale:
+			for workspaceId, results := range workspaceResults {
+				if _, failed := workspaceErrors[workspaceId]; !failed {
+					p.saveStaleResults(queryConfig, workspaceList, workspaceId, results)
+				}
+			}
+
+			for workspaceId := range workspaceErrors {
+				if staleResults, ok := p.loadStaleResults(queryConfig, workspaceList, workspaceId); ok {
+					queryLogger.Warn("serving stale results", slog.String("workspaceId", workspaceId))
+					for _, result := range staleResults {
+						queryMetricList.Add(result.Name, result.Metrics...)
+					}
+					workspaceStatus[workspaceId] = QueryStatusStale
+				}
+			}
+		}
+
+		for workspaceId, status := range workspaceStatus {
+			setQueryStatus(p.config.moduleName, queryConfig.Metric, workspaceId, status)
+
+			if status == QueryStatusSuccess {
 				prometheusQueryLastSuccessfull.With(prometheus.Labels{
 					"module":      p.config.moduleName,
 					"metric":      queryConfig.Metric,
-					"workspaceID": result.WorkspaceId,
+					"workspaceID": workspaceId,
 				}).SetToCurrentTime()
-			} else {
-				prometheusQueryStatus.With(prometheus.Labels{
-					"module":      p.config.moduleName,
-					"metric":      queryConfig.Metric,
-					"workspaceID": result.WorkspaceId,
-				}).Set(0)
-
-				queryLogger.Error(result.Error.Error())
 			}
 		}
 
+		p.limitSeries(queryLogger, queryConfig, queryMetricList)
+		for _, metricName := range queryMetricList.GetMetricNames() {
+			p.metricList.Add(metricName, queryMetricList.GetMetricList(metricName)...)
+		}
+
 		elapsedTime := time.Since(startTime)
 		queryLogger.With(slog.Int("results", resultTotalRecords)).Debug("fetched results")
 		prometheusQueryTime.With(prometheus.Labels{"module": p.config.moduleName, "metric": queryConfig.Metric}).Observe(elapsedTime.Seconds())
 		prometheusQueryResults.With(prometheus.Labels{"module": p.config.moduleName, "metric": queryConfig.Metric}).Set(float64(resultTotalRecords))
+
+		if queryErr != nil {
+			return queryErr
+		}
 	}
 
 	return nil
 }
 
-func (p *LogAnalyticsProber) queryWorkspace(workspaces []WorkspaceConfig, queryConfig kusto.Query) (azquery.LogsClientQueryWorkspaceResponse, error) {
-	clientOpts := azquery.LogsClientOptions{ClientOptions: *p.Azure.Client.NewAzCoreClientOptions()}
-	logsClient, err := azquery.NewLogsClient(p.Azure.Client.GetCred(), &clientOpts)
+// limitSeries applies the series limit per metric (query setting or default) to all metrics of the query
+func (p *LogAnalyticsProber) limitSeries(logger *slogger.Logger, queryConfig config.Query, metricList *kusto.MetricList) {
+	maxSeries := queryConfig.GetMaxSeries(p.Conf.Loganalytics.MaxSeries)
+	if maxSeries <= 0 {
+		return
+	}
+
+	for _, metricName := range metricList.GetMetricNames() {
+		p.limitMetricSeries(logger, metricList, metricName, maxSeries)
+	}
+}
+
+// limitProbeSeries applies the series budget of the probe (parameter maxSeries) to all metrics of the probe,
+// the budget is shared between the metrics, metrics with most series are limited first
+func (p *LogAnalyticsProber) limitProbeSeries() {
+	if p.config.maxSeries <= 0 {
+		return
+	}
+
+	metricNames := p.metricList.GetMetricNames()
+	seriesCounts := make([]int, len(metricNames))
+	for num, metricName := range metricNames {
+		seriesCounts[num] = countSeries(p.metricList, metricName, p.QueryConfig.GetMetricMeta(metricName))
+	}
+
+	maxSeries := seriesBudgetLimit(seriesCounts, p.config.maxSeries)
+	for num, metricName := range metricNames {
+		if seriesCounts[num] > maxSeries {
+			p.limitMetricSeries(p.logger, p.metricList, metricName, maxSeries)
+		}
+	}
+}
+
+// limitMetricSeries limits the series of the metric and reports folded or dropped series
+func (p *LogAnalyticsProber) limitMetricSeries(logger *slogger.Logger, metricList *kusto.MetricList, metricName string, maxSeries int) {
+	if dropped := limitSeries(metricList, metricName, p.QueryConfig.GetMetricMeta(metricName), maxSeries); dropped > 0 {
+		logger.Warn(
+			"series limit reached, folding series into \""+SeriesOverflowLabelValue+"\" series",
+			slog.String("metricName", metricName),
+			slog.Int("maxSeries", maxSeries),
+			slog.Int("dropped", dropped),
+		)
+		prometheusSeriesDropped.With(prometheus.Labels{"module": p.config.moduleName, "metric": metricName}).Add(float64(dropped))
+	}
+}
+
+// acquireConcurrencySlot waits for a free slot within the request limit (parallel) and the global limit (concurrency)
+// and returns the function for releasing the slots again, fails if the request is cancelled while waiting
+func (p *LogAnalyticsProber) acquireConcurrencySlot(queryConfig config.Query) (func(), error) {
+	startTime := time.Now()
+
+	// request limit first, so waiting requests don't block global slots
+	if err := p.requestConcurrencyWaitGroup.AddWithContext(p.queryCtx); err != nil {
+		return nil, err
+	}
+
+	if err := p.concurrencyWaitGroup.AddWithContext(p.queryCtx); err != nil {
+		p.requestConcurrencyWaitGroup.Done()
+		return nil, err
+	}
+
+	prometheusConcurrencyWaitTime.With(prometheus.Labels{"module": p.config.moduleName, "metric": queryConfig.Metric}).Observe(time.Since(startTime).Seconds())
+
+	return func() {
+		p.concurrencyWaitGroup.Done()
+		p.requestConcurrencyWaitGroup.Done()
+	}, nil
+}
+
+// logsClient returns the shared LogsClient from the pool or creates a new one if no pool is set
+func (p *LogAnalyticsProber) logsClient() (*azquery.LogsClient, error) {
+	if p.logsClientPool != nil {
+		return p.logsClientPool.Get(p.Azure.Client)
+	}
+
+	return azquery.NewLogsClient(p.Azure.Client.GetCred(), newLogsClientOptions(p.Azure.Client.NewAzCoreClientOptions()))
+}
+
+func (p *LogAnalyticsProber) queryWorkspace(workspaces []WorkspaceConfig, queryConfig config.Query) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	logsClient, err := p.logsClient()
 	if err != nil {
 		return azquery.LogsClientQueryWorkspaceResponse{}, err
 	}
@@ -417,26 +671,61 @@ func (p *LogAnalyticsProber) queryWorkspace(workspaces []WorkspaceConfig, queryC
 		}
 	}
 
+	ctx := p.queryCtx
 	opts := azquery.LogsClientQueryWorkspaceOptions{}
+	if timeout := queryConfig.GetTimeout(); timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+
+		// also pass timeout as server side wait preference (max 10 minutes)
+		wait := int(math.Min(math.Ceil(timeout.Seconds()), 600))
+		opts.Options = &azquery.LogsQueryOptions{
+			Wait: &wait,
+		}
+	}
+
 	queryBody := azquery.Body{
-		Query:                to.StringPtr(queryConfig.Query),
+		Query:                to.StringPtr(queryConfig.Query.Query),
 		Timespan:             timespan,
 		AdditionalWorkspaces: additionalWorkspaces,
 	}
 
-	return logsClient.QueryWorkspace(p.ctx, workspaces[0].CustomerID, queryBody, &opts)
+	return logsClient.QueryWorkspace(ctx, workspaces[0].CustomerID, queryBody, &opts)
 }
 
-func (p *LogAnalyticsProber) sendQueryToMultipleWorkspace(logger *slogger.Logger, workspaces []WorkspaceConfig, queryConfig kusto.Query, result chan<- LogAnalyticsProbeResult) {
+func (p *LogAnalyticsProber) sendQueryToMultipleWorkspace(logger *slogger.Logger, workspaces []WorkspaceConfig, queryConfig config.Query, result chan<- LogAnalyticsProbeResult) {
 	workspaceLogger := logger.With(slog.Any("workspaceId", workspaces))
 
-	workspaceLogger.With(slog.String("query", queryConfig.Query)).Debug("send query to logAnalytics workspaces")
+	workspaceLogger.With(slog.String("query", queryConfig.Query.Query)).Debug("send query to logAnalytics workspaces")
 
-	queryResults, queryErr := p.queryWorkspace(workspaces, queryConfig)
+	// lookup for mapping result rows back to their source workspace
+	var workspaceLookup map[string]WorkspaceConfig
+	if queryConfig.WorkspaceColumn != "" {
+		workspaceLookup = map[string]WorkspaceConfig{}
+		for _, workspaceConfig := range workspaces {
+			workspaceLookup[strings.ToLower(workspaceConfig.CustomerID)] = workspaceConfig
+			if workspaceConfig.ResourceID != "" {
+				workspaceLookup[strings.ToLower(workspaceConfig.ResourceID)] = workspaceConfig
+			}
+		}
+	}
+
+	queryResults, queryErr := p.queryWorkspaceWithRetry(workspaces, queryConfig)
 	if queryErr != nil {
 		workspaceLogger.Error(queryErr.Error())
-		result <- LogAnalyticsProbeResult{
-			Error: queryErr,
+		if workspaceLookup != nil {
+			// report error for every workspace
+			for _, workspaceConfig := range workspaces {
+				result <- LogAnalyticsProbeResult{
+					WorkspaceId: workspaceConfig.CustomerID,
+					Error:       queryErr,
+				}
+			}
+		} else {
+			result <- LogAnalyticsProbeResult{
+				Error: queryErr,
+			}
 		}
 		return
 	}
@@ -444,49 +733,50 @@ func (p *LogAnalyticsProber) sendQueryToMultipleWorkspace(logger *slogger.Logger
 	logger.Debug("fetched query result")
 	resultTables := queryResults.Tables
 
-	if len(resultTables) >= 1 {
-		for _, table := range resultTables {
-			if table.Rows == nil || table.Columns == nil {
-				// no results found, skip table
-				continue
+	if workspaceLookup != nil {
+		// report status for every workspace, also for workspaces without results
+		for _, workspaceConfig := range workspaces {
+			result <- LogAnalyticsProbeResult{
+				WorkspaceId: workspaceConfig.CustomerID,
 			}
+		}
+	}
 
-			for _, v := range table.Rows {
-				resultRow := map[string]interface{}{}
-
-				for colNum, colName := range resultTables[0].Columns {
-					resultRow[to.String(colName.Name)] = v[colNum]
-				}
-
-				for metricName, metric := range kusto.BuildPrometheusMetricList(queryConfig.Metric, *queryConfig.QueryMetric, resultRow) {
-					// inject workspaceId
-					for num := range metric {
-						metric[num].Labels["workspaceTable"] = to.String(table.Name)
-					}
+	p.buildMetricsFromTables(queryConfig, resultTables, func(resultRow map[string]interface{}, metricName string, metric []kusto.MetricRow) {
+		workspaceId := ""
 
-					result <- LogAnalyticsProbeResult{
-						WorkspaceId: "",
-						Name:        metricName,
-						Metrics:     metric,
-					}
-				}
+		// find source workspace of row
+		if workspaceLookup != nil {
+			workspaceValue := strings.ToLower(fmt.Sprintf("%v", resultRow[queryConfig.WorkspaceColumn]))
+			if workspaceConfig, ok := workspaceLookup[workspaceValue]; ok {
+				workspaceId = workspaceConfig.CustomerID
+				injectWorkspaceLabels(metric, workspaceConfig)
+			} else {
+				logger.Debug("unable to map result row to workspace", slog.String("value", workspaceValue))
 			}
 		}
-	}
+
+		result <- LogAnalyticsProbeResult{
+			WorkspaceId: workspaceId,
+			Name:        metricName,
+			Metrics:     metric,
+		}
+	})
 
 	logger.Debug("metrics parsed")
 }
 
-func (p *LogAnalyticsProber) sendQueryToSingleWorkspace(logger *slogger.Logger, workspaceConfig WorkspaceConfig, queryConfig kusto.Query, result chan<- LogAnalyticsProbeResult) {
+func (p *LogAnalyticsProber) sendQueryToSingleWorkspace(logger *slogger.Logger, workspaceConfig WorkspaceConfig, queryConfig config.Query, result chan<- LogAnalyticsProbeResult) {
 	workspaceLogger := logger.With(slog.String("workspaceId", workspaceConfig.CustomerID))
 
-	workspaceLogger.With(slog.String("query", queryConfig.Query)).Debug("send query to logAnalytics workspace")
+	workspaceLogger.With(slog.String("query", queryConfig.Query.Query)).Debug("send query to logAnalytics workspace")
 
-	queryResults, queryErr := p.queryWorkspace([]WorkspaceConfig{workspaceConfig}, queryConfig)
+	queryResults, queryErr := p.queryWorkspaceWithRetry([]WorkspaceConfig{workspaceConfig}, queryConfig)
 	if queryErr != nil {
 		workspaceLogger.Error(queryErr.Error())
 		result <- LogAnalyticsProbeResult{
-			Error: queryErr,
+			WorkspaceId: workspaceConfig.CustomerID,
+			Error:       queryErr,
 		}
 		return
 	}
@@ -494,49 +784,88 @@ func (p *LogAnalyticsProber) sendQueryToSingleWorkspace(logger *slogger.Logger,
 	logger.Debug("fetched query result")
 	resultTables := queryResults.Tables
 
-	if len(resultTables) >= 1 {
-		for _, table := range resultTables {
-			if table.Rows == nil || table.Columns == nil {
-				// no results found, skip table
-				continue
-			}
+	p.buildMetricsFromTables(queryConfig, resultTables, func(resultRow map[string]interface{}, metricName string, metric []kusto.MetricRow) {
+		injectWorkspaceLabels(metric, workspaceConfig)
+
+		result <- LogAnalyticsProbeResult{
+			WorkspaceId: workspaceConfig.CustomerID,
+			Name:        metricName,
+			Metrics:     metric,
+		}
+	})
+
+	logger.Debug("metrics parsed")
+}
+
+// buildMetricsFromTables converts the rows of all result tables into metrics, using the columns
+// and the field configuration (see table mapping) of each table, rows without value are handled by
+// the value policies of the value fields
+func (p *LogAnalyticsProber) buildMetricsFromTables(queryConfig config.Query, resultTables []*azquery.Table, callback func(resultRow map[string]interface{}, metricName string, metric []kusto.MetricRow)) {
+	now := time.Now()
+	timestampBin, timestampDelay := queryConfig.GetTimestampBin()
+
+	for tableNum, table := range resultTables {
+		if table.Rows == nil || table.Columns == nil {
+			// no results found, skip table
+			continue
+		}
 
-			for _, v := range table.Rows {
-				resultRow := map[string]interface{}{}
+		tableName := to.String(table.Name)
+		tableMetricName, tableMetricConfig := queryConfig.GetTableMetricConfig(tableName, tableNum)
+		tableTimestampField := queryConfig.GetTableTimestampField(tableName, tableNum)
+		tableExemplarFields := queryConfig.GetTableExemplarFields(tableName, tableNum)
+		tableValueFields := queryConfig.GetTableValueFields(tableName, tableNum)
 
-				for colNum, colName := range resultTables[0].Columns {
-					resultRow[to.String(colName.Name)] = v[colNum]
+		for _, v := range table.Rows {
+			resultRow := map[string]interface{}{}
+
+			for colNum, colName := range table.Columns {
+				resultRow[to.String(colName.Name)] = v[colNum]
+			}
+
+			// skip rows of bins which are still filling up (see timestampBin)
+			if tableTimestampField != "" && timestampBin > 0 {
+				if timestamp, ok := parseTimestampValue(resultRow[tableTimestampField]); ok && incompleteBin(timestamp, timestampBin, timestampDelay, now) {
+					prometheusValuesDropped.With(prometheus.Labels{"module": p.config.moduleName, "metric": queryConfig.Metric, "reason": "incomplete_bin"}).Inc()
+					continue
 				}
+			}
 
-				for metricName, metric := range kusto.BuildPrometheusMetricList(queryConfig.Metric, *queryConfig.QueryMetric, resultRow) {
-					// inject workspaceId
-					for num := range metric {
-						metric[num].Labels["workspaceTable"] = to.String(table.Name)
-						metric[num].Labels["workspaceID"] = workspaceConfig.CustomerID
-
-						// add labels from resource config
-						if workspaceConfig.Labels != nil {
-							for labelName, labelValue := range workspaceConfig.Labels {
-								metric[num].Labels[labelName] = labelValue
-							}
-						}
-					}
+			for metricName, metric := range kusto.BuildPrometheusMetricList(tableMetricName, tableMetricConfig, resultRow) {
+				metric, dropped := applyValuePolicies(metric, metricName, tableMetricName, resultRow, tableValueFields)
+				for reason, count := range dropped {
+					prometheusValuesDropped.With(prometheus.Labels{"module": p.config.moduleName, "metric": queryConfig.Metric, "reason": reason}).Add(float64(count))
+				}
 
-					result <- LogAnalyticsProbeResult{
-						WorkspaceId: workspaceConfig.CustomerID,
-						Name:        metricName,
-						Metrics:     metric,
-					}
+				// inject table name
+				for num := range metric {
+					metric[num].Labels["workspaceTable"] = tableName
 				}
+				injectTimestamp(metric, resultRow, tableTimestampField)
+				injectExemplarLabels(metric, resultRow, tableExemplarFields)
+
+				callback(resultRow, metricName, metric)
 			}
 		}
 	}
+}
 
-	logger.Debug("metrics parsed")
+// injectWorkspaceLabels adds the workspace id and the labels from resource config to the metrics
+func injectWorkspaceLabels(metrics []kusto.MetricRow, workspaceConfig WorkspaceConfig) {
+	for num := range metrics {
+		metrics[num].Labels["workspaceID"] = workspaceConfig.CustomerID
+
+		// add labels from resource config
+		if workspaceConfig.Labels != nil {
+			for labelName, labelValue := range workspaceConfig.Labels {
+				metrics[num].Labels[labelName] = labelValue
+			}
+		}
+	}
 }
 
-func (p *LogAnalyticsProber) parseCacheTime(r *http.Request) (time.Duration, error) {
-	durationString := r.URL.Query().Get("cache")
+func (p *LogAnalyticsProber) parseCacheTime(params url.Values) (time.Duration, error) {
+	durationString := params.Get("cache")
 	if durationString != "" {
 		if v, err := time.ParseDuration(durationString); err == nil {
 			return v, nil
@@ -547,3 +876,102 @@ func (p *LogAnalyticsProber) parseCacheTime(r *http.Request) (time.Duration, err
 
 	return 0, nil
 }
+
+// parseCacheMaxStale returns how long expired cache entries are served while being refreshed in background
+func (p *LogAnalyticsProber) parseCacheMaxStale(params url.Values) (time.Duration, error) {
+	durationString := params.Get("cacheMaxStale")
+	if durationString != "" {
+		v, err := time.ParseDuration(durationString)
+		if err != nil {
+			return 0, fmt.Errorf("parameter \"cacheMaxStale\" is invalid: %w", err)
+		}
+
+		if v < 0 {
+			return 0, fmt.Errorf("parameter \"cacheMaxStale\" must not be negative")
+		}
+
+		return v, nil
+	}
+
+	return 0, nil
+}
+
+// parseMaxSeries returns the maximum number of series of all metrics requested by the probe (0 = not set)
+func (p *LogAnalyticsProber) parseMaxSeries(params url.Values) (int, error) {
+	if val := params.Get("maxSeries"); val != "" {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, fmt.Errorf("parameter \"maxSeries\" is invalid: %w", err)
+		}
+
+		if v <= 0 {
+			return 0, fmt.Errorf("parameter \"maxSeries\" must be greater than zero")
+		}
+
+		return v, nil
+	}
+
+	return 0, nil
+}
+
+// parseParallel returns the effective number of concurrent workspace queries of this request,
+// limited by the global concurrency
+func (p *LogAnalyticsProber) parseParallel(params url.Values) (int, error) {
+	parallel := p.concurrencyWaitGroup.Size
+
+	if val := params.Get("parallel"); val != "" {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, fmt.Errorf("parameter \"parallel\" is invalid: %w", err)
+		}
+
+		if v <= 0 {
+			return 0, fmt.Errorf("parameter \"parallel\" must be greater than zero")
+		}
+
+		if v < parallel {
+			parallel = v
+		}
+	}
+
+	return parallel, nil
+}
+
+// scrapeDeadline returns the deadline for workspace queries based on the Prometheus scrape timeout header
+// and the server write timeout (both reduced by the safety offset, at most by half), nil if no deadline applies
+func (p *LogAnalyticsProber) scrapeDeadline() *time.Time {
+	var timeout *time.Duration
+
+	if p.request == nil {
+		// no http request, no scrape deadline
+		return nil
+	}
+
+	if p.Conf.Server.WriteTimeout > 0 {
+		timeout = &p.Conf.Server.WriteTimeout
+	}
+
+	if val := p.request.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); val != "" {
+		if seconds, err := strconv.ParseFloat(val, 64); err == nil && seconds > 0 {
+			scrapeTimeout := time.Duration(seconds * float64(time.Second))
+			if timeout == nil || scrapeTimeout < *timeout {
+				timeout = &scrapeTimeout
+			}
+		} else {
+			p.logger.Debug("unable to parse scrape timeout header", slog.String("value", val))
+		}
+	}
+
+	if timeout == nil {
+		return nil
+	}
+
+	// offset must not use up the whole timeout, keep at least half of the timeout for queries
+	queryTimeout := *timeout - p.Conf.Server.ScrapeTimeoutOffset
+	if queryTimeout < *timeout/2 {
+		queryTimeout = *timeout / 2
+	}
+
+	deadline := time.Now().Add(queryTimeout)
+	return &deadline
+}
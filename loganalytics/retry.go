@@ -0,0 +1,131 @@
+package loganalytics
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+const (
+	RetryReasonThrottled   = "throttled"
+	RetryReasonServerError = "server_error"
+	RetryReasonNetwork     = "network"
+)
+
+// queryWorkspaceWithRetry executes the query and retries throttled and transient errors with exponential backoff,
+// retries are bounded by the request deadline
+func (p *LogAnalyticsProber) queryWorkspaceWithRetry(workspaces []WorkspaceConfig, queryConfig config.Query) (azquery.LogsClientQueryWorkspaceResponse, error) {
+	workspaceId := ""
+	if len(workspaces) == 1 {
+		workspaceId = workspaces[0].CustomerID
+	}
+
+	for attempt := 0; ; attempt++ {
+		result, err := p.queryWorkspace(workspaces, queryConfig)
+		if err == nil || attempt >= p.Conf.Loganalytics.Retry.Count {
+			return result, err
+		}
+
+		reason, retryAfter, retryable := classifyQueryError(err)
+		if !retryable {
+			return result, err
+		}
+
+		wait := retryBackoff(attempt, p.Conf.Loganalytics.Retry.Backoff, p.Conf.Loganalytics.Retry.MaxBackoff)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+
+		// don't retry if next attempt would exceed the deadline
+		if deadline, ok := p.queryCtx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return result, err
+		}
+
+		prometheusQueryRetries.With(prometheus.Labels{
+			"workspaceID": workspaceId,
+			"module":      p.config.moduleName,
+			"metric":      queryConfig.Metric,
+			"reason":      reason,
+		}).Inc()
+		p.logger.Debugf("retrying query in %s (%s): %v", wait.String(), reason, err)
+
+		select {
+		case <-p.queryCtx.Done():
+			return result, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// classifyQueryError returns the retry reason and the requested retry delay (Retry-After header) of retryable errors
+func classifyQueryError(err error) (reason string, retryAfter time.Duration, retryable bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "", 0, false
+	}
+
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		switch responseErr.StatusCode {
+		case http.StatusTooManyRequests:
+			reason = RetryReasonThrottled
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			reason = RetryReasonServerError
+		default:
+			return "", 0, false
+		}
+
+		if responseErr.RawResponse != nil {
+			retryAfter = parseRetryAfter(responseErr.RawResponse.Header.Get("Retry-After"))
+		}
+
+		return reason, retryAfter, true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return RetryReasonNetwork, 0, true
+	}
+
+	return "", 0, false
+}
+
+// parseRetryAfter parses the Retry-After header (seconds or http date)
+func parseRetryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if retryTime, err := http.ParseTime(val); err == nil {
+		return time.Until(retryTime)
+	}
+
+	return 0
+}
+
+// retryBackoff returns the exponential backoff with full jitter for the attempt
+func retryBackoff(attempt int, backoff, maxBackoff time.Duration) time.Duration {
+	wait := backoff << attempt
+	if wait <= 0 || wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int64N(int64(wait))) // #nosec G404
+}
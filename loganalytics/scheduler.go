@@ -0,0 +1,265 @@
+package loganalytics
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/webdevops/go-common/log/slogger"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+const (
+	// SchedulerSnapshotMaxAgeIntervals is the number of job intervals a snapshot is served without successful job run
+	SchedulerSnapshotMaxAgeIntervals = 2
+)
+
+type (
+	LogAnalyticsScheduler struct {
+		logger          *slogger.Logger
+		defaultInterval time.Duration
+		queryConfig     *config.QueryConfig
+		proberFactory   LogAnalyticsProberFactory
+
+		jobs []config.JobConfig
+
+		snapshots map[string]*LogAnalyticsSnapshot
+		lock      sync.RWMutex
+	}
+
+	LogAnalyticsSnapshot struct {
+		Job        string
+		Module     string
+		MetricList *kusto.MetricList
+		Time       time.Time
+
+		// snapshots of failing jobs are not served after expiry
+		ExpiresAt time.Time
+	}
+
+	// LogAnalyticsProberFactory creates a fully configured prober (azure client, query config) for a job
+	LogAnalyticsProberFactory func(ctx context.Context, params url.Values) *LogAnalyticsProber
+)
+
+func NewLogAnalyticsScheduler(logger *slogger.Logger, defaultInterval time.Duration, queryConfig *config.QueryConfig, proberFactory LogAnalyticsProberFactory) *LogAnalyticsScheduler {
+	scheduler := LogAnalyticsScheduler{}
+	scheduler.logger = logger
+	scheduler.defaultInterval = defaultInterval
+	scheduler.queryConfig = queryConfig
+	scheduler.proberFactory = proberFactory
+	scheduler.snapshots = map[string]*LogAnalyticsSnapshot{}
+
+	return &scheduler
+}
+
+func (s *LogAnalyticsScheduler) AddJob(jobs ...config.JobConfig) {
+	s.jobs = append(s.jobs, jobs...)
+}
+
+// Start starts all jobs in background, each job is executed immediately and then on its own interval
+func (s *LogAnalyticsScheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		interval := job.GetInterval(s.defaultInterval)
+		jobLogger := s.logger.With(slog.String("job", job.GetName()), slog.String("module", job.Module))
+		jobLogger.Infof("starting job with interval %s", interval.String())
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				s.runJob(ctx, jobLogger, job, interval)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+func (s *LogAnalyticsScheduler) runJob(ctx context.Context, logger *slogger.Logger, job config.JobConfig, interval time.Duration) {
+	startTime := time.Now()
+
+	// job must not run longer than its interval
+	ctx, cancel := context.WithTimeout(ctx, interval)
+	defer cancel()
+
+	jobLabels := prometheus.Labels{"job": job.GetName(), "module": job.Module}
+	success := false
+	defer func() {
+		if success {
+			prometheusJobStatus.With(jobLabels).Set(1)
+		} else {
+			prometheusJobStatus.With(jobLabels).Set(0)
+		}
+	}()
+
+	defer func() {
+		if err := recover(); err != nil {
+			switch v := err.(type) {
+			case LogAnalyticsPanicStop:
+				// log entry already sent
+			default:
+				logger.Error(fmt.Sprintf("job failed: %v", v))
+			}
+		}
+	}()
+
+	prober := s.proberFactory(ctx, s.jobParams(job))
+	switch {
+	case job.IsServiceDiscovery():
+		prober.ServiceDiscovery.Use()
+	case job.IsManagementGroupServiceDiscovery():
+		prober.ServiceDiscovery.UseManagementGroups()
+	case job.Tenant:
+		prober.ServiceDiscovery.UseTenant()
+	case len(job.Workspaces) > 0:
+		prober.AddWorkspaces(job.Workspaces...)
+	default:
+		prober.AddWorkspaces(prober.Conf.Loganalytics.Workspace...)
+	}
+
+	if err := prober.Collect(); err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	snapshotTime := time.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.snapshots[job.GetName()] = &LogAnalyticsSnapshot{
+		Job:        job.GetName(),
+		Module:     job.Module,
+		MetricList: prober.GetMetricList(),
+		Time:       snapshotTime,
+		ExpiresAt:  snapshotTime.Add(SchedulerSnapshotMaxAgeIntervals * interval),
+	}
+
+	success = true
+	prometheusJobLastSuccess.With(jobLabels).Set(float64(snapshotTime.Unix()))
+
+	logger.With(slog.Duration("duration", time.Since(startTime))).Debug("finished job")
+}
+
+// jobParams translates the job configuration into prober parameters
+func (s *LogAnalyticsScheduler) jobParams(job config.JobConfig) url.Values {
+	params := url.Values{}
+	params.Set("module", job.Module)
+
+	if job.Optional {
+		params.Set("optional", "true")
+	}
+
+	if job.Filter != "" {
+		params.Set("filter", job.Filter)
+	}
+
+	for _, subscription := range job.Subscriptions {
+		params.Add("subscription", subscription)
+	}
+
+	for _, managementGroup := range job.ManagementGroups {
+		params.Add("managementgroup", managementGroup)
+	}
+
+	for _, tag := range job.Tags {
+		params.Add("tag", tag)
+	}
+
+	for _, resourceGroup := range job.ResourceGroups {
+		params.Add("resourceGroup", resourceGroup)
+	}
+
+	for _, location := range job.Locations {
+		params.Add("location", location)
+	}
+
+	for _, name := range job.Names {
+		params.Add("name", name)
+	}
+
+	for _, pattern := range job.NamePatterns {
+		params.Add("name~", pattern)
+	}
+
+	for _, pattern := range job.SubscriptionInclude {
+		params.Add("subscriptionInclude", pattern)
+	}
+
+	for _, pattern := range job.SubscriptionExclude {
+		params.Add("subscriptionExclude", pattern)
+	}
+
+	for _, workspace := range job.Workspaces {
+		params.Add("workspace", workspace)
+	}
+
+	return params
+}
+
+// buildRegistry merges all snapshots matching the filter into one registry, expired snapshots are skipped
+func (s *LogAnalyticsScheduler) buildRegistry(filter func(snapshot *LogAnalyticsSnapshot) bool) (*prometheus.Registry, *time.Time) {
+	var oldestSnapshot *time.Time
+	now := time.Now()
+
+	metricList := &kusto.MetricList{}
+	metricList.Init()
+
+	s.lock.RLock()
+	for _, snapshot := range s.snapshots {
+		if !filter(snapshot) || now.After(snapshot.ExpiresAt) {
+			continue
+		}
+
+		for _, metricName := range snapshot.MetricList.GetMetricNames() {
+			metricList.Add(metricName, snapshot.MetricList.GetMetricList(metricName)...)
+		}
+
+		if oldestSnapshot == nil || snapshot.Time.Before(*oldestSnapshot) {
+			snapshotTime := snapshot.Time
+			oldestSnapshot = &snapshotTime
+		}
+	}
+	s.lock.RUnlock()
+
+	registry := prometheus.NewRegistry()
+	buildPrometheusMetrics(s.logger, registry, metricList, s.queryConfig)
+
+	return registry, oldestSnapshot
+}
+
+// Gather implements prometheus.Gatherer and returns the latest snapshots of all jobs
+func (s *LogAnalyticsScheduler) Gather() ([]*dto.MetricFamily, error) {
+	registry, _ := s.buildRegistry(func(snapshot *LogAnalyticsSnapshot) bool {
+		return true
+	})
+	return newMetricFamilyGatherer(registry, s.queryConfig).Gather()
+}
+
+// ServeProbe serves the latest snapshots of all jobs matching the requested module
+func (s *LogAnalyticsScheduler) ServeProbe(w http.ResponseWriter, r *http.Request) {
+	moduleName := r.URL.Query().Get("module")
+
+	registry, snapshotTime := s.buildRegistry(func(snapshot *LogAnalyticsSnapshot) bool {
+		return snapshot.Module == moduleName
+	})
+
+	if snapshotTime != nil {
+		w.Header().Add("X-metrics-snapshot-time", snapshotTime.Format(time.RFC3339))
+	}
+
+	h := NewMetricsHandler(s.logger, newMetricFamilyGatherer(registry, s.queryConfig))
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,119 @@
+package loganalytics
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// buildWorkspaceSelectorQuery builds the ResourceGraph where clauses from the selector parameters
+// (tag, resourceGroup, location, name and name~), all values are passed as escaped string literals.
+// The raw filter parameter is only used if allowed.
+func buildWorkspaceSelectorQuery(params url.Values, allowFilter bool) (string, error) {
+	query := ""
+
+	tags, _ := ParamsGetList(params, "tag")
+	for _, tag := range tags {
+		tagName, tagValue, hasValue := strings.Cut(strings.TrimSpace(tag), ":")
+		if tagName == "" {
+			return "", fmt.Errorf("parameter \"tag\" is invalid: tag name is missing in \"%s\"", tag)
+		}
+
+		tagNameLiteral, err := kqlStringLiteral(tagName)
+		if err != nil {
+			return "", fmt.Errorf("parameter \"tag\" is invalid: %w", err)
+		}
+
+		if hasValue {
+			tagValueLiteral, err := kqlStringLiteral(tagValue)
+			if err != nil {
+				return "", fmt.Errorf("parameter \"tag\" is invalid: %w", err)
+			}
+			query += fmt.Sprintf("| where tostring(tags[%s]) =~ %s \n", tagNameLiteral, tagValueLiteral)
+		} else {
+			query += fmt.Sprintf("| where isnotempty(tostring(tags[%s])) \n", tagNameLiteral)
+		}
+	}
+
+	inSelectors := []struct {
+		param  string
+		column string
+		mapper func(string) string
+	}{
+		{param: "resourceGroup", column: "resourceGroup"},
+		{param: "location", column: "location", mapper: canonicalizeAzureLocation},
+		{param: "name", column: "name"},
+	}
+	for _, selector := range inSelectors {
+		values, _ := ParamsGetList(params, selector.param)
+
+		literals := []string{}
+		for _, value := range values {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+
+			if selector.mapper != nil {
+				value = selector.mapper(value)
+			}
+
+			literal, err := kqlStringLiteral(value)
+			if err != nil {
+				return "", fmt.Errorf("parameter \"%s\" is invalid: %w", selector.param, err)
+			}
+			literals = append(literals, literal)
+		}
+
+		if len(literals) > 0 {
+			query += fmt.Sprintf("| where %s in~ (%s) \n", selector.column, strings.Join(literals, ", "))
+		}
+	}
+
+	namePatterns, _ := ParamsGetList(params, "name~")
+	nameConditions := []string{}
+	for _, pattern := range namePatterns {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+
+		if _, err := regexp.Compile(pattern); err != nil {
+			return "", fmt.Errorf("parameter \"name~\" is invalid: %w", err)
+		}
+
+		literal, err := kqlStringLiteral(pattern)
+		if err != nil {
+			return "", fmt.Errorf("parameter \"name~\" is invalid: %w", err)
+		}
+		nameConditions = append(nameConditions, fmt.Sprintf("name matches regex %s", literal))
+	}
+	if len(nameConditions) > 0 {
+		query += fmt.Sprintf("| where %s \n", strings.Join(nameConditions, " or "))
+	}
+
+	if filter := strings.TrimSpace(params.Get("filter")); len(filter) > 0 {
+		if !allowFilter {
+			return "", errors.New("parameter \"filter\" is disabled, use selector parameters (tag, resourceGroup, location, name, name~) or enable --azure.servicediscovery.allow-filter")
+		}
+
+		filter = strings.TrimLeft(filter, "|")
+		if len(filter) >= 1 {
+			query += fmt.Sprintf("| %s \n", filter)
+		}
+	}
+
+	return query, nil
+}
+
+// kqlStringLiteral returns the value as double quoted KQL string literal, control characters are not allowed
+func kqlStringLiteral(value string) (string, error) {
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return "", errors.New("control characters are not allowed")
+	}
+
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`, nil
+}
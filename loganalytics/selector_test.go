@@ -0,0 +1,242 @@
+package loganalytics
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestKqlStringLiteral(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", value: "workspace", want: `"workspace"`},
+		{name: "empty", value: "", want: `""`},
+		{name: "double quote", value: `a"b`, want: `"a\"b"`},
+		{name: "single quote", value: `a'b`, want: `"a'b"`},
+		{name: "backslash", value: `a\b`, want: `"a\\b"`},
+		{name: "backslash before quote", value: `a\"b`, want: `"a\\\"b"`},
+		{name: "trailing backslash", value: `a\`, want: `"a\\"`},
+		{name: "pipe", value: `a" | where true | project "`, want: `"a\" | where true | project \""`},
+		{name: "unicode", value: "wörkspace", want: `"wörkspace"`},
+		{name: "newline", value: "a\nb", wantErr: true},
+		{name: "carriage return", value: "a\rb", wantErr: true},
+		{name: "tab", value: "a\tb", wantErr: true},
+		{name: "null byte", value: "a\x00b", wantErr: true},
+		{name: "escape", value: "a\x1bb", wantErr: true},
+		{name: "delete", value: "a\x7fb", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := kqlStringLiteral(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildWorkspaceSelectorQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      url.Values
+		allowFilter bool
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:   "no selectors",
+			params: url.Values{},
+			want:   "",
+		},
+		{
+			name:   "tag with value",
+			params: url.Values{"tag": {"env:prod"}},
+			want:   "| where tostring(tags[\"env\"]) =~ \"prod\" \n",
+		},
+		{
+			name:   "tag without value",
+			params: url.Values{"tag": {"env"}},
+			want:   "| where isnotempty(tostring(tags[\"env\"])) \n",
+		},
+		{
+			name:   "tag with quotes and pipe",
+			params: url.Values{"tag": {`env:prod") | project secret | where ("`}},
+			want:   "| where tostring(tags[\"env\"]) =~ \"prod\\\") | project secret | where (\\\"\" \n",
+		},
+		{
+			name:   "tag name with backslash and quote",
+			params: url.Values{"tag": {`e\n"v:x`}},
+			want:   "| where tostring(tags[\"e\\\\n\\\"v\"]) =~ \"x\" \n",
+		},
+		{
+			name:    "tag without name",
+			params:  url.Values{"tag": {":prod"}},
+			wantErr: true,
+		},
+		{
+			name:    "tag with newline",
+			params:  url.Values{"tag": {"env:prod\n| project secret"}},
+			wantErr: true,
+		},
+		{
+			name:    "tag name with control character",
+			params:  url.Values{"tag": {"e\x00nv:prod"}},
+			wantErr: true,
+		},
+		{
+			name:   "resource groups",
+			params: url.Values{"resourceGroup": {"rg1,rg2", " "}},
+			want:   "| where resourceGroup in~ (\"rg1\", \"rg2\") \n",
+		},
+		{
+			name:   "resource group with quote and pipe",
+			params: url.Values{"resourceGroup": {`rg") | project secret | where name in~ ("`}},
+			want:   "| where resourceGroup in~ (\"rg\\\") | project secret | where name in~ (\\\"\") \n",
+		},
+		{
+			name:    "resource group with newline",
+			params:  url.Values{"resourceGroup": {"rg\n| project secret"}},
+			wantErr: true,
+		},
+		{
+			name:   "location is canonicalized",
+			params: url.Values{"location": {"West Europe"}},
+			want:   "| where location in~ (\"westeurope\") \n",
+		},
+		{
+			name:   "name with backslash and quote",
+			params: url.Values{"name": {`ws\" | project secret`}},
+			want:   "| where name in~ (\"ws\\\\\\\" | project secret\") \n",
+		},
+		{
+			name:    "name with control character",
+			params:  url.Values{"name": {"ws\x1b"}},
+			wantErr: true,
+		},
+		{
+			name:   "name patterns",
+			params: url.Values{"name~": {"^prod-", "-dev$"}},
+			want:   "| where name matches regex \"^prod-\" or name matches regex \"-dev$\" \n",
+		},
+		{
+			name:   "name pattern with backslash and quote",
+			params: url.Values{"name~": {`^ws\d+"|x`}},
+			want:   "| where name matches regex \"^ws\\\\d+\\\"|x\" \n",
+		},
+		{
+			name:    "name pattern with newline",
+			params:  url.Values{"name~": {"^ws\n| project secret"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid name pattern",
+			params:  url.Values{"name~": {"(ws"}},
+			wantErr: true,
+		},
+		{
+			name:   "combined selectors",
+			params: url.Values{"tag": {"env:prod"}, "resourceGroup": {"rg1"}, "name~": {"^ws"}},
+			want:   "| where tostring(tags[\"env\"]) =~ \"prod\" \n| where resourceGroup in~ (\"rg1\") \n| where name matches regex \"^ws\" \n",
+		},
+		{
+			name:    "filter not allowed",
+			params:  url.Values{"filter": {"where resourceGroup == \"rg1\""}},
+			wantErr: true,
+		},
+		{
+			name:    "filter not allowed with selectors",
+			params:  url.Values{"resourceGroup": {"rg1"}, "filter": {"| project secret"}},
+			wantErr: true,
+		},
+		{
+			name:        "filter allowed",
+			params:      url.Values{"filter": {"where resourceGroup == \"rg1\""}},
+			allowFilter: true,
+			want:        "| where resourceGroup == \"rg1\" \n",
+		},
+		{
+			name:   "empty filter is ignored",
+			params: url.Values{"filter": {"  "}},
+			want:   "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := buildWorkspaceSelectorQuery(test.params, test.allowFilter)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWorkspaceFilterTrust(t *testing.T) {
+	params := url.Values{"filter": {"where resourceGroup == \"rg1\""}}
+
+	request := httptest.NewRequest("GET", "/probe/workspace?"+params.Encode(), nil)
+	requestProber := newLogAnalyticsProber(request.Context(), nil, request.URL.Query(), nil)
+	requestProber.request = request
+	requestProber.response = httptest.NewRecorder()
+
+	jobProber := newLogAnalyticsProber(context.Background(), nil, params, nil)
+	jobProber.trustedParams = true
+
+	tests := []struct {
+		name        string
+		prober      *LogAnalyticsProber
+		allowFilter bool
+		want        bool
+	}{
+		{name: "request", prober: requestProber, want: false},
+		{name: "request with filter enabled", prober: requestProber, allowFilter: true, want: true},
+		{name: "request cache refresh", prober: requestProber.newCacheRefresher(), want: false},
+		{name: "job", prober: jobProber, want: true},
+		{name: "job cache refresh", prober: jobProber.newCacheRefresher(), want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.prober.Conf.Azure.ServiceDiscovery.AllowFilter = test.allowFilter
+
+			if got := test.prober.allowWorkspaceFilter(); got != test.want {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+
+			_, err := buildWorkspaceSelectorQuery(test.prober.params, test.prober.allowWorkspaceFilter())
+			if test.want && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !test.want && err == nil {
+				t.Error("expected error for untrusted filter")
+			}
+		})
+	}
+}
@@ -5,19 +5,41 @@ import (
 	"crypto/sha1" // #nosec
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights"
 	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/log/slogger"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	ServiceDiscoveryScopeSubscription    = "subscription"
+	ServiceDiscoveryScopeManagementGroup = "managementgroup"
+	ServiceDiscoveryScopeTenant          = "tenant"
+
+	// serviceDiscoverySubscriptionBatchSize is the maximum number of subscriptions per ResourceGraph query
+	serviceDiscoverySubscriptionBatchSize = 1000
+
+	serviceDiscoverySubscriptionCacheKey = "sd:subscriptions"
 )
 
 type (
 	LogAnalyticsServiceDiscovery struct {
 		enabled bool
+		scope   string
 		prober  *LogAnalyticsProber
 	}
+
+	// tenantSubscription is an accessible subscription (cached separately from the workspace list)
+	tenantSubscription struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
 )
 
 func (sd *LogAnalyticsServiceDiscovery) ResourcesClient(subscriptionId string) *armoperationalinsights.WorkspacesClient {
@@ -32,8 +54,24 @@ func (sd *LogAnalyticsServiceDiscovery) ResourcesClient(subscriptionId string) *
 	return client
 }
 
+// Use enables the service discovery of workspaces in the subscriptions (parameter subscription)
 func (sd *LogAnalyticsServiceDiscovery) Use() {
 	sd.enabled = true
+	sd.scope = ServiceDiscoveryScopeSubscription
+}
+
+// UseManagementGroups enables the service discovery of workspaces in the management groups
+// including nested subscriptions (parameter managementgroup)
+func (sd *LogAnalyticsServiceDiscovery) UseManagementGroups() {
+	sd.enabled = true
+	sd.scope = ServiceDiscoveryScopeManagementGroup
+}
+
+// UseTenant enables the service discovery of workspaces in all subscriptions accessible by the exporter identity
+// (filtered by parameters subscriptionInclude and subscriptionExclude)
+func (sd *LogAnalyticsServiceDiscovery) UseTenant() {
+	sd.enabled = true
+	sd.scope = ServiceDiscoveryScopeTenant
 }
 
 func (sd *LogAnalyticsServiceDiscovery) IsCacheEnabled() bool {
@@ -79,6 +117,11 @@ func (sd *LogAnalyticsServiceDiscovery) GetWorkspace(ctx context.Context, resour
 	return &workspace.Workspace, nil
 }
 
+// allowWorkspaceFilter returns if the raw workspace filter is allowed, only if enabled or if set by jobs (trusted config)
+func (p *LogAnalyticsProber) allowWorkspaceFilter() bool {
+	return p.Conf.Azure.ServiceDiscovery.AllowFilter || p.trustedParams
+}
+
 func (sd *LogAnalyticsServiceDiscovery) ServiceDiscovery() {
 	var serviceDiscoveryCacheDuration *time.Duration
 	cacheKey := ""
@@ -86,19 +129,44 @@ func (sd *LogAnalyticsServiceDiscovery) ServiceDiscovery() {
 
 	contextLogger := prober.logger
 
-	params := prober.request.URL.Query()
+	params := prober.params
 
-	subscriptionList, err := ParamsGetListRequired(params, "subscription")
+	selectorQuery, err := buildWorkspaceSelectorQuery(params, prober.allowWorkspaceFilter())
 	if err != nil {
 		contextLogger.Error(err.Error())
 		panic(LogAnalyticsPanicStop{Message: err.Error()})
 	}
 
+	var scopeList []string
+	switch sd.scope {
+	case ServiceDiscoveryScopeTenant:
+		scopeList = sd.tenantSubscriptions(contextLogger)
+		if len(scopeList) == 0 {
+			// empty subscription list would query the default scope of ResourceGraph
+			contextLogger.Warn("no subscriptions found in tenant")
+			return
+		}
+	default:
+		scopeList, err = ParamsGetListRequired(params, sd.scope)
+		if err != nil {
+			contextLogger.Error(err.Error())
+			panic(LogAnalyticsPanicStop{Message: err.Error()})
+		}
+	}
+
+	opts := armclient.ResourceGraphOptions{}
+	switch sd.scope {
+	case ServiceDiscoveryScopeManagementGroup:
+		opts.ManagementGroups = scopeList
+	default:
+		opts.Subscriptions = scopeList
+	}
+
 	if sd.IsCacheEnabled() {
 		serviceDiscoveryCacheDuration = prober.Conf.Azure.ServiceDiscovery.CacheDuration
 		cacheKey = fmt.Sprintf(
 			"sd:%x",
-			string(sha1.New().Sum([]byte(fmt.Sprintf("%v:%v", subscriptionList, prober.request.URL.String())))), //nolint:gosec
+			string(sha1.New().Sum([]byte(fmt.Sprintf("%v:%v:%v", sd.scope, scopeList, params.Encode())))), //nolint:gosec
 		)
 
 		// try cache
@@ -106,7 +174,7 @@ func (sd *LogAnalyticsServiceDiscovery) ServiceDiscovery() {
 			if cacheData, ok := v.([]byte); ok {
 				if err := json.Unmarshal(cacheData, &prober.workspaceList); err == nil {
 					contextLogger.Debug("fetched servicediscovery from cache")
-					prober.response.Header().Add("X-servicediscovery-cached", "true")
+					prober.addResponseHeader("X-servicediscovery-cached", "true")
 					return
 				} else {
 					prober.logger.Debug("unable to parse cached servicediscovery")
@@ -116,47 +184,154 @@ func (sd *LogAnalyticsServiceDiscovery) ServiceDiscovery() {
 	}
 
 	contextLogger.Debug("requesting list for workspaces via Azure API")
-	sd.findWorkspaces(contextLogger, subscriptionList)
+	sd.findWorkspaces(contextLogger, selectorQuery, opts)
 
 	// store to cache (if enabeld)
 	if serviceDiscoveryCacheDuration != nil {
 		contextLogger.Debug("saving servicedisccovery to cache")
 		if cacheData, err := json.Marshal(prober.workspaceList); err == nil {
-			prober.response.Header().Add("X-servicediscovery-cached-until", time.Now().Add(*serviceDiscoveryCacheDuration).Format(time.RFC3339))
+			prober.addResponseHeader("X-servicediscovery-cached-until", time.Now().Add(*serviceDiscoveryCacheDuration).Format(time.RFC3339))
 			prober.cache.Set(cacheKey, cacheData, *serviceDiscoveryCacheDuration)
 			contextLogger.Debugf("saved servicediscovery to cache for %s", serviceDiscoveryCacheDuration.String())
 		}
 	}
 }
 
-func (sd *LogAnalyticsServiceDiscovery) findWorkspaces(logger *slogger.Logger, subscriptionList []string) {
+// findWorkspaces adds all workspaces found by the ResourceGraph query (with selector where clauses)
+// within the scope (subscriptions or management groups)
+func (sd *LogAnalyticsServiceDiscovery) findWorkspaces(logger *slogger.Logger, selectorQuery string, opts armclient.ResourceGraphOptions) {
 	prober := sd.prober
 
 	query := "resources \n"
 	query += "| where type =~ \"Microsoft.OperationalInsights/workspaces\" \n"
-	if filter := prober.request.URL.Query().Get("filter"); len(filter) > 0 {
-		filter = strings.TrimSpace(filter)
-		filter = strings.TrimLeft(filter, "|")
-		if len(filter) >= 1 {
-			query += fmt.Sprintf("| %s \n", filter)
+	query += selectorQuery
+	query += "| project id, customerId=properties.customerId"
+
+	subscriptionBatches := [][]string{opts.Subscriptions}
+	if len(opts.Subscriptions) > serviceDiscoverySubscriptionBatchSize {
+		subscriptionBatches = slices.Collect(slices.Chunk(opts.Subscriptions, serviceDiscoverySubscriptionBatchSize))
+	}
+
+	for _, subscriptionBatch := range subscriptionBatches {
+		batchOpts := opts
+		batchOpts.Subscriptions = subscriptionBatch
+
+		result, err := prober.Azure.Client.ExecuteResourceGraphQuery(
+			prober.ctx,
+			query,
+			batchOpts,
+		)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+
+		for _, row := range result {
+			prober.AddWorkspaces(row["id"].(string))
 		}
 	}
-	query += "| project id, customerId=properties.customerId"
+}
+
+// tenantSubscriptions returns the ids of all accessible subscriptions matching the include and exclude patterns
+// (regular expressions matched against subscription id and name), the subscription list is cached separately
+func (sd *LogAnalyticsServiceDiscovery) tenantSubscriptions(logger *slogger.Logger) []string {
+	prober := sd.prober
+
+	includePatterns, err := parseSubscriptionPatterns(prober.params, "subscriptionInclude")
+	if err != nil {
+		logger.Error(err.Error())
+		panic(LogAnalyticsPanicStop{Message: err.Error()})
+	}
+
+	excludePatterns, err := parseSubscriptionPatterns(prober.params, "subscriptionExclude")
+	if err != nil {
+		logger.Error(err.Error())
+		panic(LogAnalyticsPanicStop{Message: err.Error()})
+	}
+
+	subscriptionList := []string{}
+	for _, subscription := range sd.listTenantSubscriptions(logger) {
+		matches := func(pattern *regexp.Regexp) bool {
+			return pattern.MatchString(subscription.ID) || pattern.MatchString(subscription.Name)
+		}
+
+		if len(includePatterns) > 0 && !slices.ContainsFunc(includePatterns, matches) {
+			continue
+		}
 
-	opts := armclient.ResourceGraphOptions{
-		Subscriptions: subscriptionList,
+		if slices.ContainsFunc(excludePatterns, matches) {
+			continue
+		}
+
+		subscriptionList = append(subscriptionList, subscription.ID)
 	}
 
-	result, err := prober.Azure.Client.ExecuteResourceGraphQuery(
-		prober.ctx,
-		query,
-		opts,
-	)
+	logger.Debugf("using %v subscriptions of tenant", len(subscriptionList))
+
+	return subscriptionList
+}
+
+// listTenantSubscriptions returns all subscriptions accessible by the exporter identity (from cache if enabled)
+func (sd *LogAnalyticsServiceDiscovery) listTenantSubscriptions(logger *slogger.Logger) []tenantSubscription {
+	prober := sd.prober
+	cacheDuration := prober.Conf.Azure.ServiceDiscovery.SubscriptionCacheDuration
+	cacheEnabled := prober.cache != nil && cacheDuration != nil && cacheDuration.Seconds() > 0
+
+	subscriptionList := []tenantSubscription{}
+	if cacheEnabled {
+		if v, ok := prober.cache.Get(serviceDiscoverySubscriptionCacheKey); ok {
+			if cacheData, ok := v.([]byte); ok {
+				if err := json.Unmarshal(cacheData, &subscriptionList); err == nil {
+					logger.Debug("fetched subscriptions from cache")
+					return subscriptionList
+				}
+			}
+		}
+	}
+
+	logger.Debug("requesting list of subscriptions via Azure API")
+	subscriptions, err := prober.Azure.Client.ListSubscriptions(prober.ctx)
 	if err != nil {
 		logger.Panic(err.Error())
 	}
 
-	for _, row := range result {
-		prober.AddWorkspaces(row["id"].(string))
+	for _, subscription := range subscriptions {
+		subscriptionList = append(subscriptionList, tenantSubscription{
+			ID:   to.String(subscription.SubscriptionID),
+			Name: to.String(subscription.DisplayName),
+		})
 	}
+
+	// stable order for workspace cache key
+	slices.SortFunc(subscriptionList, func(a, b tenantSubscription) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	if cacheEnabled {
+		if cacheData, err := json.Marshal(subscriptionList); err == nil {
+			prober.cache.Set(serviceDiscoverySubscriptionCacheKey, cacheData, *cacheDuration)
+			logger.Debugf("saved subscriptions to cache for %s", cacheDuration.String())
+		}
+	}
+
+	return subscriptionList
+}
+
+// parseSubscriptionPatterns parses the regular expressions of the parameter (case-insensitive)
+func parseSubscriptionPatterns(params url.Values, name string) ([]*regexp.Regexp, error) {
+	values, _ := ParamsGetList(params, name)
+
+	patterns := []*regexp.Regexp{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		pattern, err := regexp.Compile("(?i)" + value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter \"%v\" is invalid: %w", name, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
 }
@@ -0,0 +1,100 @@
+package loganalytics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+const (
+	StaleLabelName = "stale"
+)
+
+type (
+	staleResult struct {
+		Name    string
+		Metrics []kusto.MetricRow
+	}
+)
+
+// staleCacheKey returns the cache key of the stale results of a workspace, results without workspace
+// (multi mode without workspaceColumn) are stored per workspace set of the query
+func (p *LogAnalyticsProber) staleCacheKey(queryConfig config.Query, workspaceList []WorkspaceConfig, workspaceId string) string {
+	if workspaceId == "" {
+		workspaceIds := make([]string, len(workspaceList))
+		for num, workspace := range workspaceList {
+			workspaceIds[num] = strings.ToLower(workspace.CustomerID)
+		}
+		slices.Sort(workspaceIds)
+
+		hash := sha256.Sum256([]byte(strings.Join(workspaceIds, ",")))
+		workspaceId = "workspaces:" + hex.EncodeToString(hash[:])
+	}
+
+	return fmt.Sprintf("stale:%s:%s:%s", p.config.moduleName, queryConfig.Metric, workspaceId)
+}
+
+// saveStaleResults stores the last good results of a workspace for serving them if following queries fail (onError: stale)
+func (p *LogAnalyticsProber) saveStaleResults(queryConfig config.Query, workspaceList []WorkspaceConfig, workspaceId string, results []LogAnalyticsProbeResult) {
+	if p.cache == nil {
+		return
+	}
+
+	staleResults := []staleResult{}
+	for _, result := range results {
+		staleResults = append(staleResults, staleResult{Name: result.Name, Metrics: result.Metrics})
+	}
+
+	if cacheData, err := json.Marshal(staleResults); err == nil {
+		p.cache.Set(p.staleCacheKey(queryConfig, workspaceList, workspaceId), cacheData, p.Conf.Loganalytics.StaleMaxAge)
+	}
+}
+
+// loadStaleResults returns the last good results of a workspace marked with the stale label
+func (p *LogAnalyticsProber) loadStaleResults(queryConfig config.Query, workspaceList []WorkspaceConfig, workspaceId string) ([]LogAnalyticsProbeResult, bool) {
+	if p.cache == nil {
+		return nil, false
+	}
+
+	v, ok := p.cache.Get(p.staleCacheKey(queryConfig, workspaceList, workspaceId))
+	if !ok {
+		return nil, false
+	}
+
+	cacheData, ok := v.([]byte)
+	if !ok {
+		return nil, false
+	}
+
+	staleResults := []staleResult{}
+	if err := json.Unmarshal(cacheData, &staleResults); err != nil {
+		p.logger.Debug("unable to parse cached stale results")
+		return nil, false
+	}
+
+	results := []LogAnalyticsProbeResult{}
+	for _, staleResult := range staleResults {
+		for num := range staleResult.Metrics {
+			if staleResult.Metrics[num].Labels == nil {
+				staleResult.Metrics[num].Labels = prometheus.Labels{}
+			}
+			staleResult.Metrics[num].Labels[StaleLabelName] = "true"
+		}
+
+		results = append(results, LogAnalyticsProbeResult{
+			WorkspaceId: workspaceId,
+			Name:        staleResult.Name,
+			Metrics:     staleResult.Metrics,
+		})
+	}
+
+	return results, true
+}
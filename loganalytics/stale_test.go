@@ -0,0 +1,30 @@
+package loganalytics
+
+import (
+	"testing"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+func TestStaleCacheKey(t *testing.T) {
+	prober := &LogAnalyticsProber{}
+	prober.config.moduleName = "module"
+	queryConfig := config.Query{}
+	queryConfig.Metric = "rows"
+
+	workspacesA := []WorkspaceConfig{{CustomerID: "ws-1"}, {CustomerID: "ws-2"}}
+	workspacesB := []WorkspaceConfig{{CustomerID: "ws-3"}}
+
+	if got := prober.staleCacheKey(queryConfig, workspacesA, "ws-1"); got != "stale:module:rows:ws-1" {
+		t.Errorf("got %q for workspace key", got)
+	}
+
+	if prober.staleCacheKey(queryConfig, workspacesA, "") == prober.staleCacheKey(queryConfig, workspacesB, "") {
+		t.Error("multi mode keys of different workspace sets must differ")
+	}
+
+	reversed := []WorkspaceConfig{{CustomerID: "WS-2"}, {CustomerID: "ws-1"}}
+	if prober.staleCacheKey(queryConfig, workspacesA, "") != prober.staleCacheKey(queryConfig, reversed, "") {
+		t.Error("multi mode keys of the same workspace set must be equal")
+	}
+}
@@ -0,0 +1,124 @@
+package loganalytics
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webdevops/go-common/prometheus/kusto"
+)
+
+const (
+	// TimestampLabelName is the internal label carrying the sample timestamp (unix milliseconds),
+	// it's removed before exposition
+	TimestampLabelName = "__timestamp"
+
+	timestampTrackerCleanupInterval = 5 * time.Minute
+)
+
+type (
+	// timestampTracker remembers the latest exposed timestamp per series to prevent out-of-order samples
+	timestampTracker struct {
+		series      map[string]timestampTrackerEntry
+		lastCleanup time.Time
+		lock        sync.Mutex
+	}
+
+	timestampTrackerEntry struct {
+		timestamp time.Time
+		expiry    time.Time
+	}
+)
+
+var (
+	sampleTimestamps = &timestampTracker{series: map[string]timestampTrackerEntry{}}
+)
+
+// parseTimestampValue parses a datetime column value (RFC3339 string or unix seconds)
+func parseTimestampValue(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		if timestamp, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return timestamp, true
+		}
+	case float64:
+		return time.UnixMilli(int64(v * 1000)), true
+	}
+
+	return time.Time{}, false
+}
+
+// injectTimestamp adds the timestamp from the timestamp column of the result row to the metrics
+func injectTimestamp(metrics []kusto.MetricRow, resultRow map[string]interface{}, timestampField string) {
+	if timestampField == "" {
+		return
+	}
+
+	timestamp, ok := parseTimestampValue(resultRow[timestampField])
+	if !ok {
+		return
+	}
+
+	for num := range metrics {
+		metrics[num].Labels[TimestampLabelName] = strconv.FormatInt(timestamp.UnixMilli(), 10)
+	}
+}
+
+// incompleteBin returns true if the bin starting at the timestamp is not complete yet (bin end after now minus
+// the ingestion delay), its value still changes and must not be exposed with the same timestamp
+func incompleteBin(timestamp time.Time, binSize, delay time.Duration, now time.Time) bool {
+	return binSize > 0 && timestamp.Add(binSize).After(now.Add(-delay))
+}
+
+// rowTimestamp returns the timestamp of a metric row (nil if not set)
+func rowTimestamp(row kusto.MetricRow) *time.Time {
+	if val, exists := row.Labels[TimestampLabelName]; exists {
+		if unixMilli, err := strconv.ParseInt(val, 10, 64); err == nil {
+			timestamp := time.UnixMilli(unixMilli)
+			return &timestamp
+		}
+	}
+
+	return nil
+}
+
+// checkTimestamp returns the timestamp which should be exposed for the series,
+// timestamps in the future are set to now and false is returned for samples older than
+// maxAge or older than the last exposed sample of the series (out-of-order)
+func (t *timestampTracker) checkTimestamp(metricName string, labelNames, labelValues []string, timestamp time.Time, maxAge time.Duration) (time.Time, bool) {
+	now := time.Now()
+
+	if timestamp.After(now) {
+		timestamp = now
+	}
+
+	if timestamp.Before(now.Add(-maxAge)) {
+		return timestamp, false
+	}
+
+	seriesKey := metricName + "\xff" + strings.Join(labelNames, "\xff") + "\xff" + strings.Join(labelValues, "\xff")
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if now.Sub(t.lastCleanup) > timestampTrackerCleanupInterval {
+		for key, entry := range t.series {
+			if entry.expiry.Before(now) {
+				delete(t.series, key)
+			}
+		}
+		t.lastCleanup = now
+	}
+
+	if entry, exists := t.series[seriesKey]; exists && timestamp.Before(entry.timestamp) {
+		return timestamp, false
+	}
+
+	t.series[seriesKey] = timestampTrackerEntry{
+		timestamp: timestamp,
+		expiry:    timestamp.Add(maxAge),
+	}
+
+	return timestamp, true
+}
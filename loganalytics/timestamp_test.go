@@ -0,0 +1,104 @@
+package loganalytics
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+var initTestGlobalMetricsOnce sync.Once
+
+// initTestGlobalMetrics registers the global metrics once per test run
+func initTestGlobalMetrics() {
+	initTestGlobalMetricsOnce.Do(InitGlobalMetrics)
+}
+
+func TestIncompleteBin(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 7, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		binSize   time.Duration
+		delay     time.Duration
+		want      bool
+	}{
+		{name: "no bin size", timestamp: now, want: false},
+		{name: "current bin", timestamp: now.Add(-2 * time.Minute), binSize: 5 * time.Minute, want: true},
+		{name: "previous bin", timestamp: now.Add(-7 * time.Minute), binSize: 5 * time.Minute, want: false},
+		{name: "bin ending now", timestamp: now.Add(-5 * time.Minute), binSize: 5 * time.Minute, want: false},
+		{name: "previous bin within delay", timestamp: now.Add(-7 * time.Minute), binSize: 5 * time.Minute, delay: 5 * time.Minute, want: true},
+		{name: "older bin outside delay", timestamp: now.Add(-12 * time.Minute), binSize: 5 * time.Minute, delay: 5 * time.Minute, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := incompleteBin(test.timestamp, test.binSize, test.delay, now); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildMetricsFromTablesIncompleteBin(t *testing.T) {
+	initTestGlobalMetrics()
+
+	queryConfig := config.Query{}
+	err := json.Unmarshal([]byte(`{
+		"metric": "rows",
+		"query": "Usage",
+		"timestampBin": "5m",
+		"fields": [
+			{"name": "table", "type": "id"},
+			{"name": "timestamp", "type": "timestamp"},
+			{"name": "count", "type": "value"}
+		],
+		"defaultField": {"type": "ignore"}
+	}`), &queryConfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := queryConfig.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	currentBin := time.Now().Truncate(5 * time.Minute)
+	previousBin := currentBin.Add(-5 * time.Minute)
+
+	resultTables := []*azquery.Table{
+		{
+			Name: to.Ptr("PrimaryResult"),
+			Columns: []*azquery.Column{
+				{Name: to.Ptr("table")},
+				{Name: to.Ptr("timestamp")},
+				{Name: to.Ptr("count")},
+			},
+			Rows: []azquery.Row{
+				{"a", previousBin.Format(time.RFC3339), float64(10)},
+				{"a", currentBin.Format(time.RFC3339), float64(2)},
+			},
+		},
+	}
+
+	prober := &LogAnalyticsProber{}
+	rows := []kusto.MetricRow{}
+	prober.buildMetricsFromTables(queryConfig, resultTables, func(resultRow map[string]interface{}, metricName string, metric []kusto.MetricRow) {
+		rows = append(rows, metric...)
+	})
+
+	if len(rows) != 1 {
+		t.Fatalf("got %v rows, want 1", len(rows))
+	}
+
+	if timestamp := rowTimestamp(rows[0]); timestamp == nil || !timestamp.Equal(previousBin) {
+		t.Errorf("got timestamp %v, want %v", timestamp, previousBin)
+	}
+}
@@ -0,0 +1,66 @@
+package loganalytics
+
+import (
+	"strings"
+
+	"github.com/webdevops/go-common/prometheus/kusto"
+
+	"github.com/webdevops/azure-loganalytics-exporter/config"
+)
+
+const (
+	ValueDroppedReasonNull       = "null"
+	ValueDroppedReasonNonNumeric = "non_numeric"
+)
+
+// applyValuePolicies sets the value of metric rows without value (null or non-numeric column value) using the
+// policy of their value field and returns the remaining rows and the number of dropped rows per reason
+func applyValuePolicies(metrics []kusto.MetricRow, metricName, mainMetricName string, resultRow map[string]interface{}, valueFields []config.MetricFieldExtension) ([]kusto.MetricRow, map[string]int) {
+	ret := []kusto.MetricRow{}
+	dropped := map[string]int{}
+
+	for _, row := range metrics {
+		if row.Value != nil {
+			ret = append(ret, row)
+			continue
+		}
+
+		reason := ValueDroppedReasonNull
+		var policy *config.MetricValuePolicy
+		for _, field := range valueFields {
+			if field.GetMetricName(mainMetricName) != metricName {
+				continue
+			}
+
+			if isNullValue(resultRow[field.Name]) {
+				policy = field.NullValue
+			} else {
+				reason = ValueDroppedReasonNonNumeric
+				policy = field.NonNumericValue
+			}
+			break
+		}
+
+		if value, ok := policy.Apply(); ok {
+			row.Value = &value
+			ret = append(ret, row)
+		} else {
+			dropped[reason]++
+		}
+	}
+
+	return ret, dropped
+}
+
+// isNullValue returns true if the column value is null (strings: empty or "null")
+func isNullValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		v = strings.TrimSpace(v)
+		return v == "" || strings.EqualFold(v, "null")
+	}
+
+	return false
+}
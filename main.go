@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"time"
@@ -14,11 +16,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/jessevdk/go-flags"
 	cache "github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/remeh/sizedwaitgroup"
 	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/azuresdk/prometheus/tracing"
-	"github.com/webdevops/go-common/prometheus/kusto"
 
 	"github.com/webdevops/azure-loganalytics-exporter/config"
 	"github.com/webdevops/azure-loganalytics-exporter/loganalytics"
@@ -34,7 +36,7 @@ var (
 	argparser *flags.Parser
 	Opts      config.Opts
 
-	Config kusto.Config
+	Config config.QueryConfig
 
 	AzureClient *armclient.ArmClient
 
@@ -42,6 +44,10 @@ var (
 
 	metricCache *cache.Cache
 
+	logsClientPool *loganalytics.LogsClientPool
+
+	scheduler *loganalytics.LogAnalyticsScheduler
+
 	//go:embed templates/*.html
 	templates embed.FS
 
@@ -63,6 +69,7 @@ func main() {
 	concurrentWaitGroup = sizedwaitgroup.New(Opts.Loganalytics.Concurrency)
 
 	metricCache = cache.New(120*time.Second, 60*time.Second)
+	logsClientPool = loganalytics.NewLogsClientPool()
 
 	logger.Infof("loading config")
 	readConfig()
@@ -70,6 +77,16 @@ func main() {
 	logger.Infof("init Azure")
 	initAzureConnection()
 
+	if argparser.Active != nil && argparser.Active.Name == "backfill" {
+		runBackfill()
+		return
+	}
+
+	if Opts.Scheduler.Enabled {
+		logger.Infof("starting scheduler")
+		initScheduler()
+	}
+
 	logger.Infof("starting http server on %s", Opts.Server.Bind)
 	startHttpServer()
 }
@@ -77,6 +94,7 @@ func main() {
 // init argparser and parse/validate arguments
 func initArgparser() {
 	argparser = flags.NewParser(&Opts, flags.Default)
+	argparser.SubcommandsOptional = true
 	_, err := argparser.Parse()
 
 	// check if there is an parse error
@@ -94,13 +112,31 @@ func initArgparser() {
 
 func readConfig() {
 	logger.Infof("read config %s", Opts.Config.Path)
-	Config = kusto.NewConfig(Opts.Config.Path)
+	var err error
+	Config, err = config.NewQueryConfig(Opts.Config.Path)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
 
 	if err := Config.Validate(); err != nil {
 		logger.Fatal(err.Error())
 	}
 }
 
+func initScheduler() {
+	if len(Config.Jobs) == 0 {
+		logger.Fatal("scheduler enabled but no jobs found in config")
+	}
+
+	scheduler = loganalytics.NewLogAnalyticsScheduler(logger, Opts.Scheduler.Interval, &Config, func(ctx context.Context, params url.Values) *loganalytics.LogAnalyticsProber {
+		prober := loganalytics.NewLogAnalyticsJobProber(ctx, logger, params, &concurrentWaitGroup)
+		configureLogAnalyticsProber(prober)
+		return prober
+	})
+	scheduler.AddJob(Config.Jobs...)
+	scheduler.Start(context.Background())
+}
+
 func initAzureConnection() {
 	var err error
 	AzureClient, err = armclient.NewArmClientWithCloudName(*Opts.Azure.Environment, logger.Slog())
@@ -159,11 +195,20 @@ func startHttpServer() {
 		}
 	})
 
-	mux.Handle("/metrics", tracing.RegisterAzureMetricAutoClean(promhttp.Handler()))
+	if scheduler != nil {
+		mux.Handle("/metrics", tracing.RegisterAzureMetricAutoClean(
+			loganalytics.NewMetricsHandler(logger, prometheus.Gatherers{prometheus.DefaultGatherer, scheduler}),
+		))
+	} else {
+		mux.Handle("/metrics", tracing.RegisterAzureMetricAutoClean(promhttp.Handler()))
+	}
 
 	mux.HandleFunc("/probe", handleProbeRequest)
 	mux.HandleFunc("/probe/workspace", handleProbeWorkspace)
 	mux.HandleFunc("/probe/subscription", handleProbeSubscriptionRequest)
+	mux.HandleFunc("/probe/managementgroup", handleProbeManagementGroupRequest)
+	mux.HandleFunc("/probe/tenant", handleProbeTenantRequest)
+	mux.HandleFunc("/sd/workspaces", handleServiceDiscoveryWorkspacesRequest)
 
 	srv := &http.Server{
 		Addr:         Opts.Server.Bind,
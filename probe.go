@@ -29,6 +29,12 @@ func handleProbePanic(w http.ResponseWriter, r *http.Request) {
 func handleProbeRequest(w http.ResponseWriter, r *http.Request) {
 	defer handleProbePanic(w, r)
 
+	if scheduler != nil {
+		// serve latest results of background jobs
+		scheduler.ServeProbe(w, r)
+		return
+	}
+
 	prober := NewLogAnalyticsProber(w, r)
 	prober.AddWorkspaces(Opts.Loganalytics.Workspace...)
 	prober.Run()
@@ -55,13 +61,42 @@ func handleProbeSubscriptionRequest(w http.ResponseWriter, r *http.Request) {
 	prober.Run()
 }
 
+func handleProbeManagementGroupRequest(w http.ResponseWriter, r *http.Request) {
+	defer handleProbePanic(w, r)
+
+	prober := NewLogAnalyticsProber(w, r)
+	prober.ServiceDiscovery.UseManagementGroups()
+	prober.Run()
+}
+
+func handleProbeTenantRequest(w http.ResponseWriter, r *http.Request) {
+	defer handleProbePanic(w, r)
+
+	prober := NewLogAnalyticsProber(w, r)
+	prober.ServiceDiscovery.UseTenant()
+	prober.Run()
+}
+
+func handleServiceDiscoveryWorkspacesRequest(w http.ResponseWriter, r *http.Request) {
+	defer handleProbePanic(w, r)
+
+	prober := NewLogAnalyticsProber(w, r)
+	prober.ServiceDiscovery.Use()
+	prober.ServiceDiscovery.ServeHttpServiceDiscovery(w, r)
+}
+
 func NewLogAnalyticsProber(w http.ResponseWriter, r *http.Request) *loganalytics.LogAnalyticsProber {
 	prober := loganalytics.NewLogAnalyticsProber(logger, w, r, &concurrentWaitGroup)
+	configureLogAnalyticsProber(prober)
+
+	return prober
+}
+
+func configureLogAnalyticsProber(prober *loganalytics.LogAnalyticsProber) {
 	prober.QueryConfig = Config
 	prober.Conf = Opts
 	prober.UserAgent = UserAgent + gitTag
 	prober.SetAzureClient(AzureClient)
 	prober.EnableCache(metricCache)
-
-	return prober
+	prober.EnableLogsClientPool(logsClientPool)
 }